@@ -4,13 +4,23 @@ import (
 	_ "embed"
 	"github.com/go-playground/validator/v10"
 	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/acl"
+	"github.com/yunqi/lighthouse/internal/auth"
+	_ "github.com/yunqi/lighthouse/internal/auth/static"
+	_ "github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	_ "github.com/yunqi/lighthouse/internal/persistence/queue/redis"
+	_ "github.com/yunqi/lighthouse/internal/persistence/retained/redis"
+	_ "github.com/yunqi/lighthouse/internal/persistence/retained/trie"
 	_ "github.com/yunqi/lighthouse/internal/persistence/session/memory"
 	_ "github.com/yunqi/lighthouse/internal/persistence/session/redis"
 	_ "github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
 	_ "github.com/yunqi/lighthouse/internal/persistence/subscription/redis"
+	_ "github.com/yunqi/lighthouse/internal/persistence/unack/mem"
+	_ "github.com/yunqi/lighthouse/internal/persistence/unack/redis"
 	"github.com/yunqi/lighthouse/internal/server"
 	"github.com/yunqi/lighthouse/internal/xlog"
 	"github.com/yunqi/lighthouse/internal/xtrace"
+	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 	"net/http"
 	_ "net/http/pprof"
@@ -20,7 +30,7 @@ import (
 var configBytes []byte
 
 func main() {
-	c := new(config.Config)
+	c := &config.Config{Mqtt: config.DefaultMqtt}
 	err := yaml.Unmarshal(configBytes, &c)
 	if err != nil {
 		panic(err)
@@ -42,6 +52,22 @@ func main() {
 		_ = http.ListenAndServe("localhost:6060", nil)
 	}()
 
-	newServer := server.NewServer(server.WithTcpListen(":1883"), server.WithPersistence(&c.Persistence))
-	newServer.ServeTCP()
+	authenticator, err := auth.New(&c.Auth)
+	if err != nil {
+		panic(err)
+	}
+
+	a, err := acl.New(&c.ACL)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []server.Option{server.WithPersistence(&c.Persistence), server.WithMqtt(&c.Mqtt), server.WithAuthenticator(authenticator), server.WithACL(a), server.WithShutdownTimeout(c.ShutdownTimeout)}
+	for i := range c.Listeners {
+		opts = append(opts, server.WithListener(&c.Listeners[i]))
+	}
+	newServer := server.NewServer(opts...)
+	if err = newServer.Run(); err != nil {
+		xlog.LoggerModule("main").Error("stop server", zap.Error(err))
+	}
 }
@@ -0,0 +1,29 @@
+package config
+
+type (
+	// ACL is the topic-level authorization configuration.
+	ACL struct {
+		// NoMatch is the permission if no rule matches: allow|deny, default to allow.
+		NoMatch string `yaml:"noMatch"`
+		// Rules are checked in order, the first matched rule takes effect.
+		Rules []ACLRule `yaml:"rules"`
+	}
+
+	// ACLRule is an ACL rule.
+	// The rule applies to the client only if all the non-empty Username, ClientId and IPAddr are matched.
+	ACLRule struct {
+		// Permission is the permission of the rule: allow|deny.
+		Permission string `yaml:"permission"`
+		// Action is the action of the rule: publish|subscribe|all, default to all.
+		Action string `yaml:"action"`
+		// Username is the username of the client.
+		Username string `yaml:"username"`
+		// ClientId is the client id of the client.
+		ClientId string `yaml:"clientId"`
+		// IPAddr is the IP address or CIDR of the client, e.g. 10.0.0.0/8.
+		IPAddr string `yaml:"ipAddr"`
+		// Topics are the topic filters of the rule, which support MQTT wildcards.
+		// "%u" and "%c" are replaced with the username and the client id of the client.
+		Topics []string `yaml:"topics"`
+	}
+)
@@ -0,0 +1,18 @@
+package config
+
+type (
+	// Auth is the authentication configuration of the CONNECT packet.
+	Auth struct {
+		// Providers are the authentication providers, they are tried in order (chain mode).
+		// If a provider does not know the client, the next provider will be tried.
+		// If empty, all clients are allowed to connect.
+		Providers []AuthProvider `yaml:"providers"`
+	}
+
+	// AuthProvider is the configuration of an authentication provider.
+	AuthProvider struct {
+		Type string `yaml:"type"` // static
+		// File is the user file of the static provider.
+		File string `yaml:"file"`
+	}
+)
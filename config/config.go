@@ -25,6 +25,12 @@ type Config struct {
 	Log         Log         `yaml:"log"`
 	Persistence Persistence `yaml:"persistence"`
 	Trace       Trace       `yaml:"trace"`
+	Auth        Auth        `yaml:"auth"`
+	ACL         ACL         `yaml:"acl"`
+	// Listeners are the named listeners, default to a tcp listener on ":1883" if it is empty.
+	Listeners []Listener `yaml:"listeners" validate:"dive"`
+	// ShutdownTimeout is the maximum time to wait for the server to stop gracefully on SIGINT or SIGTERM, default to 30s.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
 }
 
 type Mqtt struct {
@@ -42,21 +48,34 @@ type Mqtt struct {
 	// MaxPacketSize is the maximum packet size that the server is willing to accept from the client
 	MaxPacketSize uint32 `yaml:"maxPacketSize"`
 	// ReceiveMax limits the number of QoS 1 and QoS 2 publications that the server is willing to process concurrently for the client.
+	// The client which exceeds the limit will be disconnected, 0 means no limit.
 	ReceiveMax uint16 `yaml:"serverReceiveMaximum"`
 	// MaxKeepAlive is the maximum keep alive time in seconds allows by the server.
 	// If the client requests a keepalive time bigger than MaxKeepalive,
 	// the server will use MaxKeepAlive as the keepalive time.
 	// In this case, if the client version is v5, the server will set MaxKeepalive into CONNACK to inform the client.
 	// But if the client version is 3.x, the server has no way to inform the client that the keepalive time has been changed.
+	// 0 means no limit.
 	MaxKeepAlive uint16 `yaml:"maxKeepalive"`
+	// DefaultKeepAlive is the keep alive time in seconds used when the client sends 0, 0 means the keep alive mechanism is disabled for the client.
+	// If the client version is v5, the server will set DefaultKeepAlive into CONNACK to inform the client.
+	DefaultKeepAlive uint16 `yaml:"defaultKeepalive"`
+	// ConnectTimeout is the maximum time to wait for the CONNECT packet after the connection is accepted,
+	// the enhanced authentication must also be completed in this time. 0 means no timeout.
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
 	// TopicAliasMax indicates the highest value that the server will accept as a Topic Alias sent by the client.
 	// No-op if the client version is MQTTv3.x
+	// Topic aliases are not supported yet, the PUBLISH with a Topic Alias is rejected, so it must be 0.
 	TopicAliasMax uint16 `yaml:"topicAliasMaximum"`
 	// SubscriptionIDAvailable indicates whether the server supports Subscription Identifiers.
 	// No-op if the client version is MQTTv3.x .
 	SubscriptionIDAvailable bool `yaml:"subscriptionIdentifierAvailable"`
 	// SharedSubAvailable indicates whether the server supports Shared Subscriptions.
 	SharedSubAvailable bool `yaml:"sharedSubscriptionAvailable"`
+	// SharedSubStrategy is the strategy to select the member of a share group which receives the message.
+	// The possible value can be "random", "roundRobin", "sticky", "hashClientId", "hashTopic"
+	// or the name of a strategy registered by shared.RegisterStrategy.
+	SharedSubStrategy string `yaml:"sharedSubscriptionStrategy"`
 	// WildcardSubAvailable indicates whether the server supports Wildcard Subscriptions.
 	WildcardAvailable bool `yaml:"wildcardSubscriptionAvailable"`
 	// RetainAvailable indicates whether the server supports retained messages.
@@ -68,6 +87,7 @@ type Mqtt struct {
 	// MaxInflight limits inflight message length of the outgoing messages.
 	// Inflight message is also stored in the message queue, so it must be less than or equal to MaxQueuedMsg.
 	// Inflight message is the QoS 1 or QoS 2 message that has been sent out to a client but not been acknowledged yet.
+	// For v5 client, the Receive Maximum in CONNECT is used if it is less than MaxInflight. 0 means no limit.
 	MaxInflight uint16 `yaml:"maxInflight"`
 	// MaximumQoS is the highest QOS level permitted for a Publish.
 	MaximumQoS uint8 `yaml:"maximumQos"`
@@ -81,3 +101,27 @@ type Mqtt struct {
 	// AllowZeroLenClientId indicates whether to allow a client to connect with empty client id.
 	AllowZeroLenClientId bool `yaml:"allowZeroLenClientId"`
 }
+
+// DefaultMqtt is the default Mqtt configuration.
+var DefaultMqtt = Mqtt{
+	SessionExpiry:              2 * time.Hour,
+	SessionExpiryCheckInterval: 20 * time.Second,
+	MessageExpiry:              2 * time.Hour,
+	InflightExpiry:             30 * time.Second,
+	MaxPacketSize:              268435456,
+	ReceiveMax:                 100,
+	MaxKeepAlive:               300,
+	ConnectTimeout:             5 * time.Second,
+	TopicAliasMax:              0,
+	SubscriptionIDAvailable:    true,
+	SharedSubAvailable:         true,
+	SharedSubStrategy:          "random",
+	WildcardAvailable:          true,
+	RetainAvailable:            true,
+	MaxQueueMessages:           10000,
+	MaxInflight:                100,
+	MaximumQoS:                 2,
+	QueueQos0Msg:               true,
+	DeliveryMode:               "onlyonce",
+	AllowZeroLenClientId:       true,
+}
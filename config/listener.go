@@ -0,0 +1,48 @@
+package config
+
+import "time"
+
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolTLS  = "tls"
+	ProtocolWS   = "ws"
+	ProtocolWSS  = "wss"
+	ProtocolUnix = "unix"
+)
+
+type (
+	// Listener is the configuration of a named listener.
+	Listener struct {
+		// Name is the unique name of the listener.
+		Name string `yaml:"name" validate:"required"`
+		// Protocol is the protocol of the listener: tcp|tls|ws|wss|unix.
+		Protocol string `yaml:"protocol" validate:"oneof=tcp tls ws wss unix"`
+		// Address is the address to listen on, it is the path of the socket file if the protocol is unix.
+		Address string `yaml:"address" validate:"required"`
+		// Path is the HTTP path of the websocket endpoint, default to "/mqtt". Only for ws and wss.
+		Path string `yaml:"path"`
+		// AllowedOrigins are the allowed Origin headers of the websocket handshake, e.g. https://example.com, "*" allows any origin.
+		// Empty means only the handshake without Origin or with the same origin as the Host is allowed. Only for ws and wss.
+		AllowedOrigins []string `yaml:"allowedOrigins"`
+		// MaxConnections is the maximum number of the concurrent connections, 0 means unlimited.
+		MaxConnections int `yaml:"maxConnections" validate:"gte=0"`
+		// TLS is the TLS configuration, required for tls and wss.
+		TLS *TLS `yaml:"tls"`
+		// Auth overrides the authentication configuration for the clients of the listener.
+		Auth *Auth `yaml:"auth"`
+		// ACL overrides the ACL configuration for the clients of the listener.
+		ACL *ACL `yaml:"acl"`
+		// ProxyProtocol enables the PROXY protocol v1 and v2 if it is set.
+		ProxyProtocol *ProxyProtocol `yaml:"proxyProtocol"`
+	}
+
+	// ProxyProtocol is the configuration of the HAProxy PROXY protocol.
+	ProxyProtocol struct {
+		// TrustedCIDRs are the addresses of the proxies, e.g. 10.0.0.0/8, it must not be empty.
+		// The connections from the trusted sources must start with a PROXY header,
+		// the connections from the other sources are served as direct connections.
+		TrustedCIDRs []string `yaml:"trustedCIDRs" validate:"min=1"`
+		// HeaderTimeout is the timeout to read the PROXY header, default to 5s.
+		HeaderTimeout time.Duration `yaml:"headerTimeout"`
+	}
+)
@@ -0,0 +1,30 @@
+package config
+
+import "time"
+
+type (
+	// TLS is the TLS configuration of a listener.
+	TLS struct {
+		// CertFile is the PEM encoded certificate file of the server.
+		CertFile string `yaml:"certFile" validate:"required"`
+		// KeyFile is the PEM encoded private key file of the server.
+		KeyFile string `yaml:"keyFile" validate:"required"`
+		// ClientCAFile is the PEM encoded CA certificates file to verify the client certificates.
+		ClientCAFile string `yaml:"clientCAFile"`
+		// ClientAuth is the policy for the client certificates: none|request|require|verifyIfGiven|requireAndVerify.
+		// Default to requireAndVerify if ClientCAFile is set, otherwise none.
+		ClientAuth string `yaml:"clientAuth"`
+		// MinVersion is the minimum TLS version: 1.0|1.1|1.2|1.3, default to 1.2.
+		MinVersion string `yaml:"minVersion"`
+		// CipherSuites is the names of the enabled cipher suites for TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+		// Empty means the Go default cipher suites.
+		CipherSuites []string `yaml:"cipherSuites"`
+		// Identity derives the identity from the client certificate: cn|san, empty means disabled.
+		// It requires ClientAuth verifyIfGiven or requireAndVerify, only the verified certificate has an identity.
+		Identity string `yaml:"identity" validate:"omitempty,eq=cn|eq=san"`
+		// IdentityAs is where the identity is used: username|clientId, default to username.
+		IdentityAs string `yaml:"identityAs" validate:"omitempty,eq=username|eq=clientId"`
+		// ReloadInterval is the minimum interval to check whether the certificate files are changed, default to 10s.
+		ReloadInterval time.Duration `yaml:"reloadInterval"`
+	}
+)
@@ -0,0 +1,298 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package acl
+
+import (
+	"context"
+	"fmt"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+
+	ActionPublish   = "publish"
+	ActionSubscribe = "subscribe"
+	ActionAll       = "all"
+)
+
+type (
+	// ACL checks whether a client is allowed to publish or subscribe a topic.
+	ACL struct {
+		noMatchAllow bool
+		rules        []*rule
+		// topics stores the rule topic filters as the subscriptions of the rule index,
+		// so they are matched in the same way as the subscriptions.
+		topics    *memory.TrieDB
+		templates []ruleTopic // the rule topic filters which contain "%u" or "%c"
+	}
+
+	ruleTopic struct {
+		rule        int
+		topicFilter string
+	}
+
+	// Client is the client which is checked.
+	// The fields must not be modified after the first check,
+	// the rule topic filters which are replaced by the client are cached in it.
+	Client struct {
+		ClientId   string
+		Username   string
+		RemoteAddr net.Addr
+
+		mu        sync.Mutex
+		acl       *ACL           // the ACL which the templates are built by
+		templates *memory.TrieDB // see ACL.templateTopics
+	}
+
+	rule struct {
+		allow    bool
+		action   string
+		username string
+		clientId string
+		ipNet    *net.IPNet
+	}
+)
+
+// New returns an ACL by the configuration.
+func New(config *config.ACL) (*ACL, error) {
+	a := &ACL{topics: memory.New(), noMatchAllow: true}
+	switch config.NoMatch {
+	case "", Allow:
+	case Deny:
+		a.noMatchAllow = false
+	default:
+		return nil, fmt.Errorf("acl: invalid noMatch %q", config.NoMatch)
+	}
+	for i, c := range config.Rules {
+		r := &rule{username: c.Username, clientId: c.ClientId, action: c.Action}
+		switch c.Permission {
+		case Allow:
+			r.allow = true
+		case Deny:
+		default:
+			return nil, fmt.Errorf("acl: invalid permission %q of rule %d", c.Permission, i)
+		}
+		switch c.Action {
+		case "":
+			r.action = ActionAll
+		case ActionPublish, ActionSubscribe, ActionAll:
+		default:
+			return nil, fmt.Errorf("acl: invalid action %q of rule %d", c.Action, i)
+		}
+		if c.IPAddr != "" {
+			ipNet, err := parseIPNet(c.IPAddr)
+			if err != nil {
+				return nil, fmt.Errorf("acl: invalid ipAddr %q of rule %d", c.IPAddr, i)
+			}
+			r.ipNet = ipNet
+		}
+		for _, topic := range c.Topics {
+			if !packet.ValidTopicFilter(true, []byte(topic)) {
+				return nil, fmt.Errorf("acl: invalid topic %q of rule %d", topic, i)
+			}
+			if isTemplate(topic) {
+				a.templates = append(a.templates, ruleTopic{rule: i, topicFilter: topic})
+				continue
+			}
+			a.topics.SubscribeLocked(context.Background(), strconv.Itoa(i), &sub.Subscription{TopicFilter: topic})
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+// CheckPublish returns whether the client is allowed to publish the topic name.
+func (a *ACL) CheckPublish(client *Client, topicName string) bool {
+	return a.check(client, ActionPublish, topicName)
+}
+
+// CheckSubscribe returns whether the client is allowed to subscribe the topic filter.
+// A topic filter with wildcards is allowed only if it is covered by an allowed rule topic filter,
+// e.g. "devices/+/status" is not allowed by "devices/%c/#",
+// and it is denied if it matches any topic of a denied rule topic filter,
+// e.g. "devices/+/status" is denied by "devices/B/#".
+func (a *ACL) CheckSubscribe(client *Client, topicFilter string) bool {
+	// 共享订阅使用实际的主题过滤器进行检查
+	if strings.HasPrefix(topicFilter, "$share/") {
+		if i := strings.Index(topicFilter[len("$share/"):], "/"); i >= 0 {
+			topicFilter = topicFilter[len("$share/")+i+1:]
+		}
+	}
+	return a.check(client, ActionSubscribe, topicFilter)
+}
+
+// check evaluates the rules in order, the first applied rule decides whether the topic is allowed.
+// An allow rule applies if its topic filter covers the topic, a deny rule applies if its topic filter overlaps the topic.
+func (a *ACL) check(client *Client, action, topic string) bool {
+	templates := a.templateTopics(client)
+	covered := a.match(templates, topic, true)
+	overlapped := covered
+	if strings.ContainsAny(topic, "+#") {
+		overlapped = a.match(templates, topic, false)
+	}
+
+	indexes := make([]int, 0, len(overlapped))
+	for i := range overlapped {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	ip := remoteIP(client.RemoteAddr)
+	for _, i := range indexes {
+		rule := a.rules[i]
+		if !rule.applies(client, ip, action) {
+			continue
+		}
+		if !rule.allow {
+			return false
+		}
+		if _, ok := covered[i]; ok {
+			return true
+		}
+	}
+	return a.noMatchAllow
+}
+
+// templateTopics returns the rule topic filters whose "%u" and "%c" are replaced by the client,
+// it returns nil if there is no template.
+// They are built on the first check of the client and cached in the client.
+func (a *ACL) templateTopics(client *Client) *memory.TrieDB {
+	if len(a.templates) == 0 {
+		return nil
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.acl != a {
+		client.acl = a
+		client.templates = a.buildTemplateTopics(client)
+	}
+	return client.templates
+}
+
+func (a *ACL) buildTemplateTopics(client *Client) *memory.TrieDB {
+	r := &replacer{username: client.Username, clientId: client.ClientId}
+	db := memory.New()
+	for _, t := range a.templates {
+		if topicFilter, ok := r.replace(t.topicFilter); ok {
+			db.SubscribeLocked(context.Background(), strconv.Itoa(t.rule), &sub.Subscription{TopicFilter: topicFilter})
+		}
+	}
+	return db
+}
+
+// match returns the indexes of the rules whose topic filter covers or overlaps the topic.
+func (a *ACL) match(templates *memory.TrieDB, topic string, cover bool) map[int]struct{} {
+	rs := make(map[int]struct{})
+	collect := func(subs subscription.ClientSubscriptions) {
+		for rule := range subs {
+			i, _ := strconv.Atoi(rule)
+			rs[i] = struct{}{}
+		}
+	}
+	collect(a.topics.MatchTopicFilter(topic, cover))
+	if templates != nil {
+		collect(templates.MatchTopicFilter(topic, cover))
+	}
+	return rs
+}
+
+func (r *rule) applies(client *Client, ip net.IP, action string) bool {
+	if r.action != ActionAll && r.action != action {
+		return false
+	}
+	if r.username != "" && r.username != client.Username {
+		return false
+	}
+	if r.clientId != "" && r.clientId != client.ClientId {
+		return false
+	}
+	if r.ipNet != nil && (ip == nil || !r.ipNet.Contains(ip)) {
+		return false
+	}
+	return true
+}
+
+// parseIPNet parses the IP address or CIDR.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+func isTemplate(topicFilter string) bool {
+	return strings.Contains(topicFilter, "%u") || strings.Contains(topicFilter, "%c")
+}
+
+// replacer replaces "%u" and "%c" with the username and the client id.
+type replacer struct {
+	username string
+	clientId string
+}
+
+// replace returns false if the placeholder can not be replaced,
+// because the username or client id is empty or contains the topic level separator or wildcards.
+func (r *replacer) replace(tpl string) (string, bool) {
+	if strings.Contains(tpl, "%u") {
+		if !validLevel(r.username) {
+			return "", false
+		}
+		tpl = strings.ReplaceAll(tpl, "%u", r.username)
+	}
+	if strings.Contains(tpl, "%c") {
+		if !validLevel(r.clientId) {
+			return "", false
+		}
+		tpl = strings.ReplaceAll(tpl, "%c", r.clientId)
+	}
+	return tpl, true
+}
+
+func validLevel(lv string) bool {
+	return lv != "" && !strings.ContainsAny(lv, "/+#")
+}
@@ -0,0 +1,175 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package acl
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"net"
+	"testing"
+)
+
+func TestACL_Check(t *testing.T) {
+	a, err := New(&config.ACL{
+		NoMatch: Deny,
+		Rules: []config.ACLRule{
+			{Permission: Deny, IPAddr: "10.0.0.0/8", Topics: []string{"#"}},
+			{Permission: Allow, Topics: []string{"devices/%c/#"}},
+			{Permission: Allow, Action: ActionSubscribe, Topics: []string{"users/%u/+/status"}},
+			{Permission: Allow, Username: "admin", Topics: []string{"#"}},
+			{Permission: Allow, ClientId: "sys", IPAddr: "127.0.0.1", Topics: []string{"$SYS/#"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1883}
+	deviceA := &Client{ClientId: "A", Username: "u1", RemoteAddr: local}
+	admin := &Client{ClientId: "admin", Username: "admin", RemoteAddr: local}
+	sys := &Client{ClientId: "sys", RemoteAddr: local}
+	internal := &Client{ClientId: "A", Username: "admin", RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.1.1.1"), Port: 1883}}
+
+	tests := []struct {
+		name      string
+		client    *Client
+		subscribe bool
+		topic     string
+		allowed   bool
+	}{
+		{name: "publish own topic", client: deviceA, topic: "devices/A/temp", allowed: true},
+		{name: "publish own topic parent", client: deviceA, topic: "devices/A", allowed: true},
+		{name: "publish other topic", client: deviceA, topic: "devices/B/temp"},
+		{name: "subscribe own wildcard", client: deviceA, subscribe: true, topic: "devices/A/#", allowed: true},
+		{name: "subscribe own single level", client: deviceA, subscribe: true, topic: "devices/A/+/x", allowed: true},
+		{name: "subscribe other wildcard", client: deviceA, subscribe: true, topic: "devices/B/#"},
+		{name: "subscribe all devices", client: deviceA, subscribe: true, topic: "devices/+/temp"},
+		{name: "subscribe all devices by #", client: deviceA, subscribe: true, topic: "devices/#"},
+		{name: "shared subscription", client: deviceA, subscribe: true, topic: "$share/g/devices/A/temp", allowed: true},
+		{name: "shared subscription of other", client: deviceA, subscribe: true, topic: "$share/g/devices/B/temp"},
+		{name: "subscribe username template", client: deviceA, subscribe: true, topic: "users/u1/+/status", allowed: true},
+		{name: "publish subscribe only", client: deviceA, topic: "users/u1/x/status"},
+		{name: "admin", client: admin, subscribe: true, topic: "#", allowed: true},
+		{name: "admin system topic", client: admin, topic: "$SYS/broker"},
+		{name: "system topic", client: sys, subscribe: true, topic: "$SYS/#", allowed: true},
+		{name: "denied ip", client: internal, topic: "devices/A/temp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.subscribe {
+				assert.Equal(t, tt.allowed, a.CheckSubscribe(tt.client, tt.topic))
+			} else {
+				assert.Equal(t, tt.allowed, a.CheckPublish(tt.client, tt.topic))
+			}
+		})
+	}
+}
+
+func TestACL_denyWildcard(t *testing.T) {
+	a, err := New(&config.ACL{
+		NoMatch: Allow,
+		Rules:   []config.ACLRule{{Permission: Deny, Username: "A", Topics: []string{"devices/B/#"}}},
+	})
+	assert.NoError(t, err)
+	client := &Client{ClientId: "A", Username: "A"}
+	tests := []struct {
+		topic   string
+		allowed bool
+	}{
+		{topic: "devices/B/status"},
+		{topic: "devices/B"},
+		{topic: "devices/+/status"},
+		{topic: "devices/+"},
+		{topic: "devices/#"},
+		{topic: "+/B/status"},
+		{topic: "+/+/+"},
+		{topic: "#"},
+		{topic: "devices/C/#", allowed: true},
+		{topic: "devices/+/status/x/#"},
+		{topic: "devices/C/+", allowed: true},
+		{topic: "+/C/status", allowed: true},
+		{topic: "$SYS/#", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, a.CheckSubscribe(client, tt.topic))
+		})
+	}
+	assert.True(t, a.CheckSubscribe(&Client{ClientId: "C", Username: "C"}, "#"))
+}
+
+func TestACL_templateWildcard(t *testing.T) {
+	a, err := New(&config.ACL{
+		NoMatch: Deny,
+		Rules:   []config.ACLRule{{Permission: Allow, Topics: []string{"users/%u/#"}}},
+	})
+	assert.NoError(t, err)
+	assert.True(t, a.CheckSubscribe(&Client{ClientId: "c", Username: "u"}, "users/u/#"))
+	assert.False(t, a.CheckSubscribe(&Client{ClientId: "c", Username: "+"}, "users/x/#"))
+	assert.False(t, a.CheckSubscribe(&Client{ClientId: "c", Username: "#"}, "users/x/y"))
+	assert.False(t, a.CheckPublish(&Client{ClientId: "c", Username: "x/y"}, "users/x/y/z"))
+}
+
+func TestACL_emptyTemplate(t *testing.T) {
+	a, err := New(&config.ACL{
+		NoMatch: Deny,
+		Rules:   []config.ACLRule{{Permission: Allow, Topics: []string{"users/%u/#"}}},
+	})
+	assert.NoError(t, err)
+	assert.False(t, a.CheckPublish(&Client{ClientId: "c"}, "users//a"))
+}
+
+func TestACL_templateCache(t *testing.T) {
+	a, err := New(&config.ACL{
+		NoMatch: Deny,
+		Rules:   []config.ACLRule{{Permission: Allow, Topics: []string{"devices/%c/#"}}},
+	})
+	assert.NoError(t, err)
+	b, err := New(&config.ACL{
+		NoMatch: Deny,
+		Rules:   []config.ACLRule{{Permission: Allow, Topics: []string{"users/%c/#"}}},
+	})
+	assert.NoError(t, err)
+
+	// 模板只在第一次检查时构建
+	c := &Client{ClientId: "A"}
+	assert.True(t, a.CheckPublish(c, "devices/A/temp"))
+	templates := c.templates
+	assert.True(t, a.CheckSubscribe(c, "devices/A/#"))
+	assert.Same(t, templates, c.templates)
+
+	// 使用其他 ACL 检查时重新构建
+	assert.False(t, b.CheckPublish(c, "devices/A/temp"))
+	assert.True(t, b.CheckPublish(c, "users/A/temp"))
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *config.ACL
+	}{
+		{name: "noMatch", config: &config.ACL{NoMatch: "x"}},
+		{name: "permission", config: &config.ACL{Rules: []config.ACLRule{{Permission: "x"}}}},
+		{name: "action", config: &config.ACL{Rules: []config.ACLRule{{Permission: Allow, Action: "x"}}}},
+		{name: "ipAddr", config: &config.ACL{Rules: []config.ACLRule{{Permission: Allow, IPAddr: "x"}}}},
+		{name: "topic", config: &config.ACL{Rules: []config.ACLRule{{Permission: Allow, Topics: []string{"a/#/b"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			assert.Error(t, err)
+		})
+	}
+}
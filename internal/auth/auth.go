@@ -0,0 +1,128 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"net"
+)
+
+const (
+	Static = "static"
+)
+
+var (
+	// ErrSkip is returned by the Authenticator if it does not know the client, the next Authenticator will be tried.
+	ErrSkip = errors.New("auth: skip")
+	// ErrBadUsernameOrPassword 用户名或密码错误
+	ErrBadUsernameOrPassword = xerror.NewError(code.BadUserNameOrPassword)
+	// ErrNotAuthorized 未授权
+	ErrNotAuthorized = xerror.NewError(code.NotAuthorized)
+
+	authenticators = map[string]NewAuthenticator{}
+)
+
+type (
+	// Authenticator authenticates the client by the CONNECT packet.
+	Authenticator interface {
+		// Authenticate returns nil if the client is allowed to connect.
+		// It returns ErrSkip if the Authenticator can not decide, or an error to reject the client.
+		// If the error is a *xerror.Error, its Code is used as the CONNACK Reason Code.
+		Authenticate(ctx context.Context, connect *packet.Connect, remoteAddr net.Addr) error
+	}
+	// NewAuthenticator creates an Authenticator by the given configuration.
+	NewAuthenticator func(config *config.AuthProvider) (Authenticator, error)
+
+	// Chain tries the authenticators in order until one of them allows or rejects the client.
+	Chain []Authenticator
+
+	tlsStateKey   struct{}
+	serverNameKey struct{}
+)
+
+// NewContextWithTLS returns a context which carries the TLS connection state of the client.
+func NewContextWithTLS(ctx context.Context, state *tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsStateKey{}, state)
+}
+
+// TLSFromContext returns the TLS connection state of the client, nil if the client is not connected over TLS.
+func TLSFromContext(ctx context.Context) *tls.ConnectionState {
+	state, _ := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state
+}
+
+// NewContextWithServerName returns a context which carries the TLS SNI sent by the client.
+func NewContextWithServerName(ctx context.Context, serverName string) context.Context {
+	return context.WithValue(ctx, serverNameKey{}, serverName)
+}
+
+// ServerNameFromContext returns the TLS SNI sent by the client,
+// it is from the PROXY header if the TLS is terminated by the proxy. Empty if it is not present.
+func ServerNameFromContext(ctx context.Context) string {
+	serverName, _ := ctx.Value(serverNameKey{}).(string)
+	return serverName
+}
+
+func RegisterAuthenticator(name string, fn NewAuthenticator) {
+	authenticators[name] = fn
+}
+
+func GetAuthenticator(name string) (fn NewAuthenticator, ok bool) {
+	fn, ok = authenticators[name]
+	return fn, ok
+}
+
+// New returns the Authenticator of the configuration, nil means all clients are allowed.
+func New(config *config.Auth) (Authenticator, error) {
+	if len(config.Providers) == 0 {
+		return nil, nil
+	}
+	chain := make(Chain, 0, len(config.Providers))
+	for i := range config.Providers {
+		provider := &config.Providers[i]
+		fn, ok := GetAuthenticator(provider.Type)
+		if !ok {
+			return nil, fmt.Errorf("auth: invalid provider type %q", provider.Type)
+		}
+		a, err := fn(provider)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	return chain, nil
+}
+
+// Authenticate implements Authenticator.
+// The client is rejected with ErrBadUsernameOrPassword if all the authenticators skip it.
+func (c Chain) Authenticate(ctx context.Context, connect *packet.Connect, remoteAddr net.Addr) error {
+	for _, a := range c {
+		err := a.Authenticate(ctx, connect, remoteAddr)
+		if err == ErrSkip {
+			continue
+		}
+		return err
+	}
+	return ErrBadUsernameOrPassword
+}
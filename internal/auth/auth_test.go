@@ -0,0 +1,71 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"net"
+	"testing"
+)
+
+type authenticatorFunc func(connect *packet.Connect) error
+
+func (f authenticatorFunc) Authenticate(_ context.Context, connect *packet.Connect, _ net.Addr) error {
+	return f(connect)
+}
+
+func TestChain_Authenticate(t *testing.T) {
+	allowFoo := authenticatorFunc(func(connect *packet.Connect) error {
+		if string(connect.Username) == "foo" {
+			return nil
+		}
+		return ErrSkip
+	})
+	rejectBar := authenticatorFunc(func(connect *packet.Connect) error {
+		if string(connect.Username) == "bar" {
+			return ErrNotAuthorized
+		}
+		return ErrSkip
+	})
+	chain := Chain{rejectBar, allowFoo}
+	tests := []struct {
+		username string
+		err      error
+	}{
+		{username: "foo"},
+		{username: "bar", err: ErrNotAuthorized},
+		{username: "baz", err: ErrBadUsernameOrPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			err := chain.Authenticate(context.Background(), &packet.Connect{Username: []byte(tt.username)}, nil)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(&config.Auth{})
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	_, err = New(&config.Auth{Providers: []config.AuthProvider{{Type: "unknown"}}})
+	assert.Error(t, err)
+}
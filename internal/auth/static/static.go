@@ -0,0 +1,96 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package static
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+)
+
+var _ auth.Authenticator = (*Authenticator)(nil)
+
+func init() {
+	auth.RegisterAuthenticator(auth.Static, NewAuthenticator())
+}
+
+type (
+	// Authenticator authenticates the client by the static users which are loaded from a YAML file.
+	// The file looks like:
+	//
+	//  users:
+	//    - username: foo
+	//      password: $2a$10$...  # bcrypt hash
+	Authenticator struct {
+		users map[string][]byte // [username]bcrypt hash
+	}
+
+	// User is the user in the YAML file.
+	User struct {
+		Username string `yaml:"username"`
+		// Password is the bcrypt hash of the password.
+		Password string `yaml:"password"`
+	}
+
+	file struct {
+		Users []User `yaml:"users"`
+	}
+)
+
+func NewAuthenticator() auth.NewAuthenticator {
+	return func(config *config.AuthProvider) (auth.Authenticator, error) {
+		b, err := os.ReadFile(config.File)
+		if err != nil {
+			return nil, err
+		}
+		return Parse(b)
+	}
+}
+
+// Parse returns an Authenticator by the content of the YAML file.
+func Parse(b []byte) (*Authenticator, error) {
+	f := &file{}
+	if err := yaml.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+	a := &Authenticator{users: make(map[string][]byte, len(f.Users))}
+	for _, u := range f.Users {
+		a.users[u.Username] = []byte(u.Password)
+	}
+	return a, nil
+}
+
+// Authenticate implements auth.Authenticator.
+// It returns auth.ErrSkip if the user does not exist.
+func (a *Authenticator) Authenticate(_ context.Context, connect *packet.Connect, _ net.Addr) error {
+	if !connect.UsernameFlag {
+		return auth.ErrSkip
+	}
+	hash, ok := a.users[string(connect.Username)]
+	if !ok {
+		return auth.ErrSkip
+	}
+	if bcrypt.CompareHashAndPassword(hash, connect.Password) != nil {
+		return auth.ErrBadUsernameOrPassword
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package static
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "users.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("users:\n  - username: foo\n    password: "+string(hash)+"\n"), 0600))
+
+	fn, ok := auth.GetAuthenticator(auth.Static)
+	assert.True(t, ok)
+	a, err := fn(&config.AuthProvider{Type: auth.Static, File: file})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		connect  *packet.Connect
+		expected error
+	}{
+		{
+			name:    "correct password",
+			connect: &packet.Connect{ConnectFlags: packet.ConnectFlags{UsernameFlag: true, PasswordFlag: true}, Username: []byte("foo"), Password: []byte("secret")},
+		},
+		{
+			name:     "wrong password",
+			connect:  &packet.Connect{ConnectFlags: packet.ConnectFlags{UsernameFlag: true, PasswordFlag: true}, Username: []byte("foo"), Password: []byte("wrong")},
+			expected: auth.ErrBadUsernameOrPassword,
+		},
+		{
+			name:     "unknown user",
+			connect:  &packet.Connect{ConnectFlags: packet.ConnectFlags{UsernameFlag: true}, Username: []byte("bar")},
+			expected: auth.ErrSkip,
+		},
+		{
+			name:     "anonymous",
+			connect:  &packet.Connect{},
+			expected: auth.ErrSkip,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, a.Authenticate(context.Background(), tt.connect, nil))
+		})
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	_, err := NewAuthenticator()(&config.AuthProvider{File: filepath.Join(t.TempDir(), "not-exist.yaml")})
+	assert.Error(t, err)
+}
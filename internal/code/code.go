@@ -50,6 +50,7 @@ const (
 	ServerUnavailable           Code = 0x88
 	ServerBusy                  Code = 0x89
 	Banned                      Code = 0x8A
+	ServerShuttingDown          Code = 0x8B
 	BadAuthMethod               Code = 0x8C
 	KeepAliveTimeout            Code = 0x8D
 	SessionTakenOver            Code = 0x8E
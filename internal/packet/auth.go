@@ -0,0 +1,99 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"io"
+)
+
+type (
+	// Auth represents the MQTT v5 AUTH packet which is used for the extended authentication exchange.
+	// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901217
+	Auth struct {
+		Version     Version
+		FixedHeader *FixedHeader
+		// Code is the Authenticate Reason Code.
+		// 0x00 = Success, 0x18 = Continue authentication, 0x19 = Re-authenticate.
+		Code code.Code
+		// Properties is the AUTH Properties.
+		Properties *Properties
+	}
+)
+
+// NewAuth returns an Auth instance by the given FixHeader and io.Reader.
+func NewAuth(fixedHeader *FixedHeader, version Version, r io.Reader) (*Auth, error) {
+	// The AUTH packet is only available in MQTT v5.
+	if !IsVersion5(version) {
+		return nil, xerror.ErrProtocol
+	}
+	//判断 标志位 flags 是否合法[MQTT-3.15.1-1]
+	if fixedHeader.Flags != FixedHeaderFlagReserved {
+		return nil, xerror.ErrMalformed
+	}
+	p := &Auth{FixedHeader: fixedHeader, Version: version}
+	err := p.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (a *Auth) Encode(w io.Writer) (err error) {
+	a.FixedHeader = &FixedHeader{PacketType: AUTH, Flags: FixedHeaderFlagReserved}
+	buf := &bytes.Buffer{}
+	// The Reason Code and Property Length can be omitted if the Reason Code is 0x00 (Success) and there are no Properties.
+	if a.Code != code.Success || a.Properties != nil {
+		buf.WriteByte(a.Code)
+		if err = a.Properties.Encode(buf, AUTH); err != nil {
+			return err
+		}
+	}
+	return encode(a.FixedHeader, buf, w)
+}
+
+func (a *Auth) Decode(r io.Reader) (err error) {
+	if a.FixedHeader.RemainLength == 0 {
+		a.Code = code.Success
+		return
+	}
+	b := make([]byte, a.FixedHeader.RemainLength)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	buf := bytes.NewBuffer(b)
+	a.Code, err = buf.ReadByte()
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	if a.Code != code.Success && a.Code != code.ContinueAuthentication && a.Code != code.ReAuthenticate {
+		return xerror.ErrProtocol
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	a.Properties = &Properties{}
+	return a.Properties.Decode(buf, AUTH)
+}
+
+func (a *Auth) String() string {
+	return fmt.Sprintf("Auth - Version: %s, Code: %v, Properties: %s", a.Version, a.Code, a.Properties)
+}
@@ -0,0 +1,57 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func TestNewAuth(t *testing.T) {
+	t.Run("v3 test", func(t *testing.T) {
+		fixedHeader := &FixedHeader{PacketType: AUTH, Flags: FixedHeaderFlagReserved}
+		auth, err := NewAuth(fixedHeader, Version311, &bytes.Buffer{})
+		assert.ErrorIs(t, err, xerror.ErrProtocol)
+		assert.Nil(t, auth)
+	})
+	t.Run("Flags test", func(t *testing.T) {
+		fixedHeader := &FixedHeader{PacketType: AUTH, Flags: 1}
+		auth, err := NewAuth(fixedHeader, Version5, &bytes.Buffer{})
+		assert.ErrorIs(t, err, xerror.ErrMalformed)
+		assert.Nil(t, auth)
+	})
+	t.Run("invalid reason code", func(t *testing.T) {
+		fixedHeader := &FixedHeader{PacketType: AUTH, Flags: FixedHeaderFlagReserved, RemainLength: 1}
+		auth, err := NewAuth(fixedHeader, Version5, bytes.NewBuffer([]byte{0x80}))
+		assert.ErrorIs(t, err, xerror.ErrProtocol)
+		assert.Nil(t, auth)
+	})
+	t.Run("auth data without auth method", func(t *testing.T) {
+		fixedHeader := &FixedHeader{PacketType: AUTH, Flags: FixedHeaderFlagReserved, RemainLength: 5}
+		auth, err := NewAuth(fixedHeader, Version5, bytes.NewBuffer([]byte{0x18, 3, PropAuthData, 0, 0}))
+		assert.ErrorIs(t, err, xerror.ErrProtocol)
+		assert.Nil(t, auth)
+	})
+}
+
+func TestAuth_Encode(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	assert.NoError(t, (&Auth{Version: Version5}).Encode(buffer))
+	assert.Equal(t, []byte{0xf0, 0x0}, buffer.Bytes())
+}
@@ -29,6 +29,8 @@ type Connack struct {
 	FixedHeader    *FixedHeader
 	SessionPresent bool
 	Code           code.Code
+	// Properties is the CONNACK Properties of MQTT v5.
+	Properties *Properties
 }
 
 // NewConnack returns a Connack instance by the given FixHeader and io.Reader
@@ -55,6 +57,11 @@ func (c *Connack) Encode(w io.Writer) (err error) {
 	}
 	// Connect Return code
 	buf.WriteByte(c.Code)
+	if IsVersion5(c.Version) {
+		if err = c.Properties.Encode(buf, CONNACK); err != nil {
+			return err
+		}
+	}
 
 	return encode(c.FixedHeader, buf, w)
 }
@@ -69,6 +76,9 @@ func (c *Connack) Decode(r io.Reader) (err error) {
 	buf := bytes.NewBuffer(restBuffer)
 	// 当前会话
 	sessionPresentByte, err := buf.ReadByte()
+	if err != nil {
+		return xerror.ErrMalformed
+	}
 	if (127 & (sessionPresentByte >> 1)) > 0 {
 		return xerror.ErrMalformed
 	}
@@ -79,10 +89,18 @@ func (c *Connack) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	c.Code = codeByte
+	if IsVersion5(c.Version) {
+		c.Properties = &Properties{}
+		return c.Properties.Decode(buf, CONNACK)
+	}
 	return
 }
 
 func (c *Connack) String() string {
-	return fmt.Sprintf("Connack - Version: %s, SessionPresent: %v, Code: %v",
+	s := fmt.Sprintf("Connack - Version: %s, SessionPresent: %v, Code: %v",
 		c.Version, c.SessionPresent, c.Code)
+	if IsVersion5(c.Version) {
+		s += fmt.Sprintf(", Properties: %s", c.Properties)
+	}
+	return s
 }
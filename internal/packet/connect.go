@@ -46,11 +46,16 @@ type (
 
 		WillTopic   []byte
 		WillMessage []byte
+		// WillProperties is the Will Properties of MQTT v5.
+		WillProperties *Properties
 
 		//auth
 		ClientId []byte
 		Username []byte
 		Password []byte
+
+		// Properties is the CONNECT Properties of MQTT v5.
+		Properties *Properties
 	}
 	ConnectFlags struct {
 
@@ -145,6 +150,11 @@ func (c *Connect) Encode(w io.Writer) (err error) {
 	connectFlags := usernameFlag | passwordFlag | willRetain | willFlag | willQos | CleanSession | reserved
 	buf.Write([]byte{connectFlags})
 	writeUint16(buf, c.KeepAlive)
+	if IsVersion5(c.Version) {
+		if err = c.Properties.Encode(buf, CONNECT); err != nil {
+			return err
+		}
+	}
 
 	// client identifier
 	clientIdBytes, _, err := UTF8EncodedStrings(c.ClientId)
@@ -153,6 +163,11 @@ func (c *Connect) Encode(w io.Writer) (err error) {
 	}
 	buf.Write(clientIdBytes)
 	if c.WillFlag {
+		if IsVersion5(c.Version) {
+			if err = c.WillProperties.Encode(buf, willProperties); err != nil {
+				return err
+			}
+		}
 		// will topic
 		willTopicBytes, _, err := UTF8EncodedStrings(c.WillTopic)
 		if err != nil {
@@ -204,7 +219,8 @@ func (c *Connect) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	c.Version = Version(c.ProtocolLevel)
-	if _, ok := version2protocolName[c.Version]; !ok {
+	// 协议名必须与协议级别匹配 [MQTT-3.1.2-1]
+	if name, ok := version2protocolName[c.Version]; !ok || name != string(protocolName) {
 		return xerror.ErrV3UnacceptableProtocolVersion
 	}
 	connectFlags, err := buf.ReadByte()
@@ -221,23 +237,39 @@ func (c *Connect) Decode(r io.Reader) (err error) {
 	if !c.WillFlag && c.WillQoS != 0 { //[MQTT-3.1.2-11]
 		return xerror.ErrMalformed
 	}
+	if c.WillQoS > QoS2 { //[MQTT-3.1.2-14]
+		return xerror.ErrMalformed
+	}
 	c.WillRetain = (1 & (connectFlags >> 5)) > 0
 	if !c.WillFlag && c.WillRetain { //[MQTT-3.1.2-11]
 		return xerror.ErrMalformed
 	}
 	c.PasswordFlag = (1 & (connectFlags >> 6)) > 0
 	c.UsernameFlag = (1 & (connectFlags >> 7)) > 0
+	if IsVersion3(c.Version) && !c.UsernameFlag && c.PasswordFlag { //[MQTT-3.1.2-22]
+		return xerror.ErrMalformed
+	}
 	c.KeepAlive, err = readUint16(buf)
 	if err != nil {
 		return err
 	}
+	if IsVersion5(c.Version) {
+		c.Properties = &Properties{}
+		if err = c.Properties.Decode(buf, CONNECT); err != nil {
+			return err
+		}
+	}
 	return c.decodePayload(buf)
 }
 
 func (c *Connect) String() string {
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		"Connect - Version: %s,ProtocolLevel: %v, UsernameFlag: %v, PasswordFlag: %v, ProtocolName: %s, CleanSession: %v, KeepAlive: %v, ClientId: %s, Username: %s, WillFlag: %v, WillRetain: %v, WillQos: %v, WillTopic: %s, WillMessage: %s",
 		c.Version, c.ProtocolLevel, c.ConnectFlags.UsernameFlag, c.ConnectFlags.PasswordFlag, c.ProtocolName, c.ConnectFlags.CleanSession, c.KeepAlive, c.ClientId, c.Username, c.ConnectFlags.WillFlag, c.ConnectFlags.WillRetain, c.ConnectFlags.WillQoS, c.WillTopic, c.WillMessage)
+	if IsVersion5(c.Version) {
+		s += fmt.Sprintf(", Properties: %s, WillProperties: %s", c.Properties, c.WillProperties)
+	}
+	return s
 }
 
 func (c *Connect) decodePayload(buf *bytes.Buffer) error {
@@ -251,11 +283,18 @@ func (c *Connect) decodePayload(buf *bytes.Buffer) error {
 		return xerror.ErrV3IdentifierRejected // v311 //[MQTT-3.1.3-8]
 	}
 	if c.WillFlag {
+		if IsVersion5(c.Version) {
+			c.WillProperties = &Properties{}
+			if err = c.WillProperties.Decode(buf, willProperties); err != nil {
+				return err
+			}
+		}
 		c.WillTopic, err = UTF8DecodedStrings(true, buf)
 		if err != nil {
 			return err
 		}
-		c.WillMessage, err = UTF8DecodedStrings(true, buf)
+		// The Will Payload is binary data.
+		c.WillMessage, err = UTF8DecodedStrings(false, buf)
 		if err != nil {
 			return err
 		}
@@ -269,7 +308,8 @@ func (c *Connect) decodePayload(buf *bytes.Buffer) error {
 	}
 
 	if c.PasswordFlag {
-		c.Password, err = UTF8DecodedStrings(true, buf)
+		// The Password is binary data.
+		c.Password, err = UTF8DecodedStrings(false, buf)
 		if err != nil {
 			return err
 		}
@@ -277,8 +317,26 @@ func (c *Connect) decodePayload(buf *bytes.Buffer) error {
 	return nil
 }
 
+// v5ToV3Code maps the MQTT v5 CONNACK Reason Code to the MQTT v3 Connect Return code.
+var v5ToV3Code = map[code.Code]code.Code{
+	code.UnsupportedProtocolVersion: code.V3UnacceptableProtocolVersion,
+	code.ClientIdentifierNotValid:   code.V3IdentifierRejected,
+	code.ServerUnavailable:          code.V3ServerUnavaliable,
+	code.ServerBusy:                 code.V3ServerUnavaliable,
+	code.BadUserNameOrPassword:      code.V3BadUsernameorPassword,
+	code.NotAuthorized:              code.V3NotAuthorized,
+}
+
 // NewConnackPacket returns the Connack struct which is the ack packet of the Connect packet.
+// For MQTT v3 client, the v5 Reason Code will be converted to the v3 Connect Return code.
 func (c *Connect) NewConnackPacket(cd code.Code, sessionReuse bool) *Connack {
+	if IsVersion3(c.Version) && cd >= code.UnspecifiedError {
+		if v3, ok := v5ToV3Code[cd]; ok {
+			cd = v3
+		} else {
+			cd = code.V3NotAuthorized
+		}
+	}
 	ack := &Connack{Code: cd, Version: c.Version}
 	if !c.CleanSession && sessionReuse && cd == code.Success {
 		ack.SessionPresent = true //[MQTT-3.2.2-2]
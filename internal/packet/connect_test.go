@@ -161,6 +161,61 @@ func TestNewConnect(t *testing.T) {
 		assert.Nil(t, connect)
 	})
 
+	t.Run("will QoS 3 error", func(t *testing.T) {
+		fixedHeader := &FixedHeader{
+			PacketType:   CONNECT,
+			Flags:        FixedHeaderFlagReserved,
+			RemainLength: 13,
+		}
+		connectBytes := bytes.NewBuffer([]byte{
+			0x00, 0x04, 'M', 'Q', 'T', 'T', // Protocol name
+			0x04,      // Protocol Level
+			0x1c,      // Connect Flags
+			0x0, 0x02, // Keep Alive
+			0x00, 0x01, 't', // Client Identifier
+		})
+		connect, err := NewConnect(fixedHeader, Version311, connectBytes)
+		assert.ErrorIs(t, err, xerror.ErrMalformed)
+		assert.Nil(t, connect)
+	})
+
+	t.Run("protocol name mismatch error", func(t *testing.T) {
+		fixedHeader := &FixedHeader{
+			PacketType:   CONNECT,
+			Flags:        FixedHeaderFlagReserved,
+			RemainLength: 13,
+		}
+		connectBytes := bytes.NewBuffer([]byte{
+			0x00, 0x04, 'M', 'Q', 'T', 'T', // Protocol name
+			0x03,      // Protocol Level
+			0x0,       // Connect Flags
+			0x0, 0x02, // Keep Alive
+			0x00, 0x01, 't', // Client Identifier
+		})
+		connect, err := NewConnect(fixedHeader, Version311, connectBytes)
+		assert.ErrorIs(t, err, xerror.ErrV3UnacceptableProtocolVersion)
+		assert.Nil(t, connect)
+	})
+
+	t.Run("Password without Username error", func(t *testing.T) {
+		fixedHeader := &FixedHeader{
+			PacketType:   CONNECT,
+			Flags:        FixedHeaderFlagReserved,
+			RemainLength: 17,
+		}
+		connectBytes := bytes.NewBuffer([]byte{
+			0x00, 0x04, 'M', 'Q', 'T', 'T', // Protocol name
+			0x04,      // Protocol Level
+			0x40,      // Connect Flags
+			0x0, 0x02, // Keep Alive
+			0x00, 0x01, 't', // Client Identifier
+			0x00, 0x02, 't', '2', // Password
+		})
+		connect, err := NewConnect(fixedHeader, Version311, connectBytes)
+		assert.ErrorIs(t, err, xerror.ErrMalformed)
+		assert.Nil(t, connect)
+	})
+
 	t.Run("Will Retain error", func(t *testing.T) {
 		fixedHeader := &FixedHeader{
 			PacketType:   CONNECT,
@@ -299,7 +354,7 @@ func TestConnect_Encode(t *testing.T) {
 	connectBytes := bytes.NewBuffer([]byte{
 		0x00, 0x04, 'M', 'Q', 'T', 'T', // Protocol name
 		0x04,      // Protocol Level
-		0xfe,      // Connect Flags
+		0xf6,      // Connect Flags
 		0x0, 0x02, // Keep Alive
 		0x00, 0x01, 't', // Client Identifier
 		0x00, 0x01, 't', // Will Topic
@@ -308,6 +363,7 @@ func TestConnect_Encode(t *testing.T) {
 		0x00, 0x01, 't', // Password
 	})
 	connect, err := NewConnect(fixedHeader, Version311, connectBytes)
+	assert.NoError(t, err)
 	buffer := &bytes.Buffer{}
 	err = connect.Encode(buffer)
 	assert.NoError(t, err)
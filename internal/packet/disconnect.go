@@ -17,7 +17,9 @@
 package packet
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -26,6 +28,10 @@ type (
 	Disconnect struct {
 		Version     Version
 		FixedHeader *FixedHeader
+		// Code is the Disconnect Reason Code of MQTT v5.
+		Code code.Code
+		// Properties is the DISCONNECT Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -35,7 +41,6 @@ func NewDisconnect(fixedHeader *FixedHeader, version Version, r io.Reader) (*Dis
 		return nil, xerror.ErrMalformed
 	}
 	p := &Disconnect{FixedHeader: fixedHeader, Version: version}
-	p.FixedHeader.RemainLength = 0
 	err := p.Decode(r)
 	if err != nil {
 		return nil, err
@@ -45,13 +50,45 @@ func NewDisconnect(fixedHeader *FixedHeader, version Version, r io.Reader) (*Dis
 
 func (d *Disconnect) Encode(w io.Writer) (err error) {
 	d.FixedHeader = &FixedHeader{PacketType: DISCONNECT, Flags: FixedHeaderFlagReserved}
-	return d.FixedHeader.Encode(w)
+	buf := &bytes.Buffer{}
+	// The Reason Code and Property Length can be omitted if the Reason Code is 0x00 (Normal disconnection) and there are no Properties.
+	if IsVersion5(d.Version) && (d.Code != code.NormalDisconnection || d.Properties != nil) {
+		buf.WriteByte(d.Code)
+		if d.Properties != nil {
+			if err = d.Properties.Encode(buf, DISCONNECT); err != nil {
+				return err
+			}
+		}
+	}
+	return encode(d.FixedHeader, buf, w)
 }
 
-func (d *Disconnect) Decode(_ io.Reader) (err error) {
-	return
+func (d *Disconnect) Decode(r io.Reader) (err error) {
+	if IsVersion3(d.Version) || d.FixedHeader.RemainLength == 0 {
+		// MQTT v3 DISCONNECT has no variable header and no payload.
+		d.FixedHeader.RemainLength = 0
+		return
+	}
+	b := make([]byte, d.FixedHeader.RemainLength)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	buf := bytes.NewBuffer(b)
+	d.Code, err = buf.ReadByte()
+	if err != nil {
+		return xerror.ErrMalformed
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	d.Properties = &Properties{}
+	return d.Properties.Decode(buf, DISCONNECT)
 }
 
 func (d *Disconnect) String() string {
+	if IsVersion5(d.Version) {
+		return fmt.Sprintf("Disconnect - Version: %s, Code: %v, Properties: %s", d.Version, d.Code, d.Properties)
+	}
 	return fmt.Sprintf("Disconnect - Version: %s", d.Version)
 }
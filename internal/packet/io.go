@@ -74,6 +74,11 @@ func (r *Reader) Read() (p Packet, err error) {
 	return
 }
 
+// SetVersion sets the protocol version which is used to decode the following packets.
+func (r *Reader) SetVersion(version Version) {
+	r.version = version
+}
+
 // NewWriter returns a new Writer.
 func NewWriter(w io.Writer) *Writer {
 	if bufw, ok := w.(*bufio.Writer); ok {
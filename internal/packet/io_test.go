@@ -161,3 +161,105 @@ func TestWriter_WriteAndFlush(t *testing.T) {
 	}, buffer.Bytes())
 
 }
+
+func TestReadWrite_V5(t *testing.T) {
+	reasonString := &Properties{ReasonString: []byte("reason")}
+	tests := []struct {
+		name   string
+		packet Packet
+	}{
+		{
+			name: "connect",
+			packet: &Connect{
+				Version:       Version5,
+				FixedHeader:   &FixedHeader{PacketType: CONNECT},
+				ProtocolName:  []byte("MQTT"),
+				ProtocolLevel: byte(Version5),
+				ConnectFlags:  ConnectFlags{CleanSession: true, WillFlag: true, WillQoS: QoS1, PasswordFlag: true, UsernameFlag: true},
+				KeepAlive:     30,
+				WillTopic:     []byte("will"),
+				WillMessage:   []byte{0xff, 0x00},
+				ClientId:      []byte("id"),
+				Username:      []byte("user"),
+				Password:      []byte{0xff},
+				Properties: &Properties{
+					SessionExpiryInterval: uint32Ptr(10),
+					ReceiveMaximum:        uint16Ptr(20),
+				},
+				WillProperties: &Properties{WillDelayInterval: uint32Ptr(5)},
+			},
+		},
+		{
+			name: "connack",
+			packet: &Connack{
+				Version:        Version5,
+				SessionPresent: true,
+				Properties:     &Properties{AssignedClientId: []byte("id"), ServerKeepAlive: uint16Ptr(10)},
+			},
+		},
+		{
+			name: "publish",
+			packet: &Publish{
+				Version:    Version5,
+				QoS:        QoS1,
+				PacketId:   1,
+				TopicName:  []byte("a/b"),
+				Payload:    []byte("payload"),
+				Properties: &Properties{User: []UserProperty{{K: []byte("k"), V: []byte("v")}}},
+			},
+		},
+		{
+			name: "publish with topic alias",
+			packet: &Publish{
+				Version:    Version5,
+				TopicName:  []byte{},
+				Payload:    []byte("payload"),
+				Properties: &Properties{TopicAlias: uint16Ptr(1)},
+			},
+		},
+		{name: "puback", packet: &Puback{Version: Version5, PacketId: 1}},
+		{name: "puback with code", packet: &Puback{Version: Version5, PacketId: 1, Code: 0x10}},
+		{name: "pubrec", packet: &Pubrec{Version: Version5, PacketId: 1, Code: 0x80, Properties: reasonString}},
+		{name: "pubrel", packet: &Pubrel{Version: Version5, PacketId: 1, Code: 0x92, Properties: reasonString}},
+		{name: "pubcomp", packet: &Pubcomp{Version: Version5, PacketId: 1, Code: 0x92, Properties: reasonString}},
+		{
+			name: "subscribe",
+			packet: &Subscribe{
+				Version:  Version5,
+				PacketId: 1,
+				Topics: []*Topic{
+					{Name: "a/+", SubOptions: SubOptions{QoS: QoS2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}},
+					{Name: "$share/g/a", SubOptions: SubOptions{QoS: QoS1}},
+				},
+				Properties: &Properties{SubscriptionIdentifier: []uint32{10}},
+			},
+		},
+		{name: "suback", packet: &Suback{Version: Version5, PacketId: 1, Payload: []byte{0x00, 0x80}, Properties: reasonString}},
+		{name: "unsubscribe", packet: &Unsubscribe{Version: Version5, PacketId: 1, Topics: []string{"a/b"}, Properties: &Properties{}}},
+		{name: "unsuback", packet: &Unsuback{Version: Version5, PacketId: 1, Payload: []byte{0x00, 0x11}, Properties: reasonString}},
+		{name: "disconnect", packet: &Disconnect{Version: Version5}},
+		{name: "auth", packet: &Auth{Version: Version5}},
+		{
+			name: "auth with properties",
+			packet: &Auth{
+				Version:    Version5,
+				Code:       0x18,
+				Properties: &Properties{AuthMethod: []byte("SCRAM-SHA-256"), AuthData: []byte("data")},
+			},
+		},
+		{name: "disconnect with code only", packet: &Disconnect{Version: Version5, Code: 0x8B}},
+		{name: "disconnect with code", packet: &Disconnect{Version: Version5, Code: 0x8E, Properties: reasonString}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.NoError(t, NewWriter(buf).WritePacketAndFlush(tt.packet))
+			reader := NewReader(buf)
+			reader.SetVersion(Version5)
+			p, err := reader.Read()
+			assert.NoError(t, err)
+			assert.Zero(t, buf.Len())
+			assert.Equal(t, tt.packet, p)
+		})
+	}
+}
@@ -83,8 +83,8 @@ const (
 	PINGRESP
 	// DISCONNECT Client is disconnecting
 	DISCONNECT
-	// AUTHReserved Forbidden
-	AUTHReserved
+	// AUTH Authentication exchange
+	AUTH
 
 	// Flag in the FixHeader
 
@@ -361,10 +361,11 @@ func NewPacket(fixedHeader *FixedHeader, version Version, r io.Reader) (Packet,
 	case DISCONNECT:
 		return NewDisconnect(fixedHeader, version, r)
 	case UNSUBACK:
-		return NewSuback(fixedHeader, version, r)
+		return NewUnsuback(fixedHeader, version, r)
 	case PINGRESP:
 		return NewPingresp(fixedHeader, r)
-	//case AUTH:
+	case AUTH:
+		return NewAuth(fixedHeader, version, r)
 	default:
 		return nil, xerror.ErrProtocol
 	}
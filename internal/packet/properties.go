@@ -0,0 +1,560 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/yunqi/lighthouse/internal/xerror"
+)
+
+// willProperties is a pseudo packet type which is used to validate the Will Properties in CONNECT packet.
+const willProperties Type = 0xFF
+
+// propertyToPacket describes which packets the property is allowed to appear in.
+// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901029
+var propertyToPacket = map[byte]map[Type]struct{}{
+	PropPayloadFormat:          {PUBLISH: {}, willProperties: {}},
+	PropMessageExpiry:          {PUBLISH: {}, willProperties: {}},
+	PropContentType:            {PUBLISH: {}, willProperties: {}},
+	PropResponseTopic:          {PUBLISH: {}, willProperties: {}},
+	PropCorrelationData:        {PUBLISH: {}, willProperties: {}},
+	PropSubscriptionIdentifier: {PUBLISH: {}, SUBSCRIBE: {}},
+	PropSessionExpiryInterval:  {CONNECT: {}, CONNACK: {}, DISCONNECT: {}},
+	PropAssignedClientID:       {CONNACK: {}},
+	PropServerKeepAlive:        {CONNACK: {}},
+	PropAuthMethod:             {CONNECT: {}, CONNACK: {}, AUTH: {}},
+	PropAuthData:               {CONNECT: {}, CONNACK: {}, AUTH: {}},
+	PropRequestProblemInfo:     {CONNECT: {}},
+	PropWillDelayInterval:      {willProperties: {}},
+	PropRequestResponseInfo:    {CONNECT: {}},
+	PropResponseInfo:           {CONNACK: {}},
+	PropServerReference:        {CONNACK: {}, DISCONNECT: {}},
+	PropReasonString:           {CONNACK: {}, PUBACK: {}, PUBREC: {}, PUBREL: {}, PUBCOMP: {}, SUBACK: {}, UNSUBACK: {}, DISCONNECT: {}, AUTH: {}},
+	PropReceiveMaximum:         {CONNECT: {}, CONNACK: {}},
+	PropTopicAliasMaximum:      {CONNECT: {}, CONNACK: {}},
+	PropTopicAlias:             {PUBLISH: {}},
+	PropMaximumQOS:             {CONNACK: {}},
+	PropRetainAvailable:        {CONNACK: {}},
+	PropUser:                   {CONNECT: {}, CONNACK: {}, PUBLISH: {}, PUBACK: {}, PUBREC: {}, PUBREL: {}, PUBCOMP: {}, SUBSCRIBE: {}, SUBACK: {}, UNSUBSCRIBE: {}, UNSUBACK: {}, DISCONNECT: {}, AUTH: {}, willProperties: {}},
+	PropMaximumPacketSize:      {CONNECT: {}, CONNACK: {}},
+	PropWildcardSubAvailable:   {CONNACK: {}},
+	PropSubIDAvailable:         {CONNACK: {}},
+	PropSharedSubAvailable:     {CONNACK: {}},
+}
+
+type (
+	// UserProperty is the key-value pair of the User Property.
+	UserProperty struct {
+		K []byte
+		V []byte
+	}
+
+	// Properties represents the properties of the MQTT v5 packet.
+	// Whether a property is allowed depends on the packet type, see propertyToPacket.
+	// The nil field means the property is absent.
+	Properties struct {
+		// PayloadFormat Payload Format Indicator
+		PayloadFormat *PayloadFormat
+		// MessageExpiry Message Expiry Interval in seconds
+		MessageExpiry *uint32
+		// ContentType Content Type
+		ContentType []byte
+		// ResponseTopic Response Topic
+		ResponseTopic []byte
+		// CorrelationData Correlation Data
+		CorrelationData []byte
+		// SubscriptionIdentifier Subscription Identifier
+		SubscriptionIdentifier []uint32
+		// SessionExpiryInterval Session Expiry Interval in seconds
+		SessionExpiryInterval *uint32
+		// AssignedClientId Assigned Client Identifier
+		AssignedClientId []byte
+		// ServerKeepAlive Server Keep Alive in seconds
+		ServerKeepAlive *uint16
+		// AuthMethod Authentication Method
+		AuthMethod []byte
+		// AuthData Authentication Data
+		AuthData []byte
+		// RequestProblemInfo Request Problem Information
+		RequestProblemInfo *byte
+		// WillDelayInterval Will Delay Interval in seconds
+		WillDelayInterval *uint32
+		// RequestResponseInfo Request Response Information
+		RequestResponseInfo *byte
+		// ResponseInfo Response Information
+		ResponseInfo []byte
+		// ServerReference Server Reference
+		ServerReference []byte
+		// ReasonString Reason String
+		ReasonString []byte
+		// ReceiveMaximum Receive Maximum
+		ReceiveMaximum *uint16
+		// TopicAliasMaximum Topic Alias Maximum
+		TopicAliasMaximum *uint16
+		// TopicAlias Topic Alias
+		TopicAlias *uint16
+		// MaximumQoS Maximum QoS
+		MaximumQoS *byte
+		// RetainAvailable Retain Available
+		RetainAvailable *byte
+		// User User Property
+		User []UserProperty
+		// MaximumPacketSize Maximum Packet Size
+		MaximumPacketSize *uint32
+		// WildcardSubAvailable Wildcard Subscription Available
+		WildcardSubAvailable *byte
+		// SubIDAvailable Subscription Identifier Available
+		SubIDAvailable *byte
+		// SharedSubAvailable Shared Subscription Available
+		SharedSubAvailable *byte
+	}
+)
+
+func (p *Properties) String() string {
+	if p == nil {
+		return "<nil>"
+	}
+	var buf bytes.Buffer
+	if p.PayloadFormat != nil {
+		buf.WriteString(fmt.Sprintf("PayloadFormat: %d, ", *p.PayloadFormat))
+	}
+	if p.MessageExpiry != nil {
+		buf.WriteString(fmt.Sprintf("MessageExpiry: %d, ", *p.MessageExpiry))
+	}
+	if p.ContentType != nil {
+		buf.WriteString(fmt.Sprintf("ContentType: %s, ", p.ContentType))
+	}
+	if p.ResponseTopic != nil {
+		buf.WriteString(fmt.Sprintf("ResponseTopic: %s, ", p.ResponseTopic))
+	}
+	if p.CorrelationData != nil {
+		buf.WriteString(fmt.Sprintf("CorrelationData: %v, ", p.CorrelationData))
+	}
+	if p.SubscriptionIdentifier != nil {
+		buf.WriteString(fmt.Sprintf("SubscriptionIdentifier: %v, ", p.SubscriptionIdentifier))
+	}
+	if p.SessionExpiryInterval != nil {
+		buf.WriteString(fmt.Sprintf("SessionExpiryInterval: %d, ", *p.SessionExpiryInterval))
+	}
+	if p.AssignedClientId != nil {
+		buf.WriteString(fmt.Sprintf("AssignedClientId: %s, ", p.AssignedClientId))
+	}
+	if p.ServerKeepAlive != nil {
+		buf.WriteString(fmt.Sprintf("ServerKeepAlive: %d, ", *p.ServerKeepAlive))
+	}
+	if p.AuthMethod != nil {
+		buf.WriteString(fmt.Sprintf("AuthMethod: %s, ", p.AuthMethod))
+	}
+	if p.AuthData != nil {
+		buf.WriteString(fmt.Sprintf("AuthData: %v, ", p.AuthData))
+	}
+	if p.RequestProblemInfo != nil {
+		buf.WriteString(fmt.Sprintf("RequestProblemInfo: %d, ", *p.RequestProblemInfo))
+	}
+	if p.WillDelayInterval != nil {
+		buf.WriteString(fmt.Sprintf("WillDelayInterval: %d, ", *p.WillDelayInterval))
+	}
+	if p.RequestResponseInfo != nil {
+		buf.WriteString(fmt.Sprintf("RequestResponseInfo: %d, ", *p.RequestResponseInfo))
+	}
+	if p.ResponseInfo != nil {
+		buf.WriteString(fmt.Sprintf("ResponseInfo: %s, ", p.ResponseInfo))
+	}
+	if p.ServerReference != nil {
+		buf.WriteString(fmt.Sprintf("ServerReference: %s, ", p.ServerReference))
+	}
+	if p.ReasonString != nil {
+		buf.WriteString(fmt.Sprintf("ReasonString: %s, ", p.ReasonString))
+	}
+	if p.ReceiveMaximum != nil {
+		buf.WriteString(fmt.Sprintf("ReceiveMaximum: %d, ", *p.ReceiveMaximum))
+	}
+	if p.TopicAliasMaximum != nil {
+		buf.WriteString(fmt.Sprintf("TopicAliasMaximum: %d, ", *p.TopicAliasMaximum))
+	}
+	if p.TopicAlias != nil {
+		buf.WriteString(fmt.Sprintf("TopicAlias: %d, ", *p.TopicAlias))
+	}
+	if p.MaximumQoS != nil {
+		buf.WriteString(fmt.Sprintf("MaximumQoS: %d, ", *p.MaximumQoS))
+	}
+	if p.RetainAvailable != nil {
+		buf.WriteString(fmt.Sprintf("RetainAvailable: %d, ", *p.RetainAvailable))
+	}
+	if p.User != nil {
+		buf.WriteString("User: [")
+		for _, v := range p.User {
+			buf.WriteString(fmt.Sprintf("{%s: %s}", v.K, v.V))
+		}
+		buf.WriteString("], ")
+	}
+	if p.MaximumPacketSize != nil {
+		buf.WriteString(fmt.Sprintf("MaximumPacketSize: %d, ", *p.MaximumPacketSize))
+	}
+	if p.WildcardSubAvailable != nil {
+		buf.WriteString(fmt.Sprintf("WildcardSubAvailable: %d, ", *p.WildcardSubAvailable))
+	}
+	if p.SubIDAvailable != nil {
+		buf.WriteString(fmt.Sprintf("SubIDAvailable: %d, ", *p.SubIDAvailable))
+	}
+	if p.SharedSubAvailable != nil {
+		buf.WriteString(fmt.Sprintf("SharedSubAvailable: %d, ", *p.SharedSubAvailable))
+	}
+	return buf.String()
+}
+
+// Encode encodes the properties into bytes and writes it into the buffer, including the property length.
+// The properties which are not allowed in the packet type are ignored.
+// If p is nil, only a zero property length will be written.
+func (p *Properties) Encode(w *bytes.Buffer, packetType Type) error {
+	if p == nil {
+		w.WriteByte(0)
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if p.PayloadFormat != nil && allowed(PropPayloadFormat, packetType) {
+		buf.WriteByte(PropPayloadFormat)
+		buf.WriteByte(*p.PayloadFormat)
+	}
+	if p.MessageExpiry != nil && allowed(PropMessageExpiry, packetType) {
+		buf.WriteByte(PropMessageExpiry)
+		writeUint32(buf, *p.MessageExpiry)
+	}
+	if p.ContentType != nil && allowed(PropContentType, packetType) {
+		buf.WriteByte(PropContentType)
+		writeBinary(buf, p.ContentType)
+	}
+	if p.ResponseTopic != nil && allowed(PropResponseTopic, packetType) {
+		buf.WriteByte(PropResponseTopic)
+		writeBinary(buf, p.ResponseTopic)
+	}
+	if p.CorrelationData != nil && allowed(PropCorrelationData, packetType) {
+		buf.WriteByte(PropCorrelationData)
+		writeBinary(buf, p.CorrelationData)
+	}
+	if allowed(PropSubscriptionIdentifier, packetType) {
+		for _, v := range p.SubscriptionIdentifier {
+			b, err := EncodeRemainLength(int(v))
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(PropSubscriptionIdentifier)
+			buf.Write(b)
+		}
+	}
+	if p.SessionExpiryInterval != nil && allowed(PropSessionExpiryInterval, packetType) {
+		buf.WriteByte(PropSessionExpiryInterval)
+		writeUint32(buf, *p.SessionExpiryInterval)
+	}
+	if p.AssignedClientId != nil && allowed(PropAssignedClientID, packetType) {
+		buf.WriteByte(PropAssignedClientID)
+		writeBinary(buf, p.AssignedClientId)
+	}
+	if p.ServerKeepAlive != nil && allowed(PropServerKeepAlive, packetType) {
+		buf.WriteByte(PropServerKeepAlive)
+		writeUint16(buf, *p.ServerKeepAlive)
+	}
+	if p.AuthMethod != nil && allowed(PropAuthMethod, packetType) {
+		buf.WriteByte(PropAuthMethod)
+		writeBinary(buf, p.AuthMethod)
+	}
+	if p.AuthData != nil && allowed(PropAuthData, packetType) {
+		buf.WriteByte(PropAuthData)
+		writeBinary(buf, p.AuthData)
+	}
+	if p.RequestProblemInfo != nil && allowed(PropRequestProblemInfo, packetType) {
+		buf.WriteByte(PropRequestProblemInfo)
+		buf.WriteByte(*p.RequestProblemInfo)
+	}
+	if p.WillDelayInterval != nil && allowed(PropWillDelayInterval, packetType) {
+		buf.WriteByte(PropWillDelayInterval)
+		writeUint32(buf, *p.WillDelayInterval)
+	}
+	if p.RequestResponseInfo != nil && allowed(PropRequestResponseInfo, packetType) {
+		buf.WriteByte(PropRequestResponseInfo)
+		buf.WriteByte(*p.RequestResponseInfo)
+	}
+	if p.ResponseInfo != nil && allowed(PropResponseInfo, packetType) {
+		buf.WriteByte(PropResponseInfo)
+		writeBinary(buf, p.ResponseInfo)
+	}
+	if p.ServerReference != nil && allowed(PropServerReference, packetType) {
+		buf.WriteByte(PropServerReference)
+		writeBinary(buf, p.ServerReference)
+	}
+	if p.ReasonString != nil && allowed(PropReasonString, packetType) {
+		buf.WriteByte(PropReasonString)
+		writeBinary(buf, p.ReasonString)
+	}
+	if p.ReceiveMaximum != nil && allowed(PropReceiveMaximum, packetType) {
+		buf.WriteByte(PropReceiveMaximum)
+		writeUint16(buf, *p.ReceiveMaximum)
+	}
+	if p.TopicAliasMaximum != nil && allowed(PropTopicAliasMaximum, packetType) {
+		buf.WriteByte(PropTopicAliasMaximum)
+		writeUint16(buf, *p.TopicAliasMaximum)
+	}
+	if p.TopicAlias != nil && allowed(PropTopicAlias, packetType) {
+		buf.WriteByte(PropTopicAlias)
+		writeUint16(buf, *p.TopicAlias)
+	}
+	if p.MaximumQoS != nil && allowed(PropMaximumQOS, packetType) {
+		buf.WriteByte(PropMaximumQOS)
+		buf.WriteByte(*p.MaximumQoS)
+	}
+	if p.RetainAvailable != nil && allowed(PropRetainAvailable, packetType) {
+		buf.WriteByte(PropRetainAvailable)
+		buf.WriteByte(*p.RetainAvailable)
+	}
+	if allowed(PropUser, packetType) {
+		for _, v := range p.User {
+			buf.WriteByte(PropUser)
+			writeBinary(buf, v.K)
+			writeBinary(buf, v.V)
+		}
+	}
+	if p.MaximumPacketSize != nil && allowed(PropMaximumPacketSize, packetType) {
+		buf.WriteByte(PropMaximumPacketSize)
+		writeUint32(buf, *p.MaximumPacketSize)
+	}
+	if p.WildcardSubAvailable != nil && allowed(PropWildcardSubAvailable, packetType) {
+		buf.WriteByte(PropWildcardSubAvailable)
+		buf.WriteByte(*p.WildcardSubAvailable)
+	}
+	if p.SubIDAvailable != nil && allowed(PropSubIDAvailable, packetType) {
+		buf.WriteByte(PropSubIDAvailable)
+		buf.WriteByte(*p.SubIDAvailable)
+	}
+	if p.SharedSubAvailable != nil && allowed(PropSharedSubAvailable, packetType) {
+		buf.WriteByte(PropSharedSubAvailable)
+		buf.WriteByte(*p.SharedSubAvailable)
+	}
+	length, err := EncodeRemainLength(buf.Len())
+	if err != nil {
+		return err
+	}
+	w.Write(length)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Decode reads the property length and the properties from the buffer, and validates them by the packet type.
+func (p *Properties) Decode(r *bytes.Buffer, packetType Type) error {
+	length, err := decodeVariableByteInteger(r)
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		return nil
+	}
+	if r.Len() < length {
+		return xerror.ErrMalformed
+	}
+	buf := bytes.NewBuffer(r.Next(length))
+	// the properties which have been read, used to check the duplicated properties.
+	read := make(map[byte]struct{})
+	for buf.Len() != 0 {
+		id, err := decodeVariableByteInteger(buf)
+		if err != nil {
+			return err
+		}
+		propId := byte(id)
+		if id > 0xFF {
+			return xerror.ErrMalformed
+		}
+		if _, ok := propertyToPacket[propId]; !ok {
+			return xerror.ErrMalformed
+		}
+		if !allowed(propId, packetType) {
+			return xerror.ErrProtocol
+		}
+		// It is a Protocol Error to include any property other than User Property and
+		// Subscription Identifier (in PUBLISH) more than once.
+		if _, ok := read[propId]; ok {
+			if propId != PropUser && !(propId == PropSubscriptionIdentifier && packetType == PUBLISH) {
+				return xerror.ErrProtocol
+			}
+		}
+		read[propId] = struct{}{}
+		if err = p.decodeProperty(propId, buf); err != nil {
+			return err
+		}
+	}
+	// It is a Protocol Error to include Authentication Data if there is no Authentication Method.
+	if p.AuthData != nil && p.AuthMethod == nil {
+		return xerror.ErrProtocol
+	}
+	return nil
+}
+
+func (p *Properties) decodeProperty(propId byte, buf *bytes.Buffer) (err error) {
+	switch propId {
+	case PropPayloadFormat:
+		p.PayloadFormat, err = readBoolByte(buf)
+	case PropMessageExpiry:
+		p.MessageExpiry, err = readUint32Ptr(buf)
+	case PropContentType:
+		p.ContentType, err = UTF8DecodedStrings(true, buf)
+	case PropResponseTopic:
+		p.ResponseTopic, err = UTF8DecodedStrings(true, buf)
+		if err == nil && !ValidTopicName(true, p.ResponseTopic) {
+			err = xerror.ErrProtocol
+		}
+	case PropCorrelationData:
+		p.CorrelationData, err = UTF8DecodedStrings(false, buf)
+	case PropSubscriptionIdentifier:
+		var id int
+		id, err = decodeVariableByteInteger(buf)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return xerror.ErrProtocol
+		}
+		p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, uint32(id))
+	case PropSessionExpiryInterval:
+		p.SessionExpiryInterval, err = readUint32Ptr(buf)
+	case PropAssignedClientID:
+		p.AssignedClientId, err = UTF8DecodedStrings(true, buf)
+	case PropServerKeepAlive:
+		p.ServerKeepAlive, err = readUint16Ptr(buf)
+	case PropAuthMethod:
+		p.AuthMethod, err = UTF8DecodedStrings(true, buf)
+	case PropAuthData:
+		p.AuthData, err = UTF8DecodedStrings(false, buf)
+	case PropRequestProblemInfo:
+		p.RequestProblemInfo, err = readBoolByte(buf)
+	case PropWillDelayInterval:
+		p.WillDelayInterval, err = readUint32Ptr(buf)
+	case PropRequestResponseInfo:
+		p.RequestResponseInfo, err = readBoolByte(buf)
+	case PropResponseInfo:
+		p.ResponseInfo, err = UTF8DecodedStrings(true, buf)
+	case PropServerReference:
+		p.ServerReference, err = UTF8DecodedStrings(true, buf)
+	case PropReasonString:
+		p.ReasonString, err = UTF8DecodedStrings(true, buf)
+	case PropReceiveMaximum:
+		p.ReceiveMaximum, err = readUint16Ptr(buf)
+		if err == nil && *p.ReceiveMaximum == 0 {
+			err = xerror.ErrProtocol
+		}
+	case PropTopicAliasMaximum:
+		p.TopicAliasMaximum, err = readUint16Ptr(buf)
+	case PropTopicAlias:
+		p.TopicAlias, err = readUint16Ptr(buf)
+		if err == nil && *p.TopicAlias == 0 {
+			err = xerror.ErrProtocol
+		}
+	case PropMaximumQOS:
+		p.MaximumQoS, err = readBoolByte(buf)
+	case PropRetainAvailable:
+		p.RetainAvailable, err = readBoolByte(buf)
+	case PropUser:
+		var k, v []byte
+		k, err = UTF8DecodedStrings(true, buf)
+		if err != nil {
+			return err
+		}
+		v, err = UTF8DecodedStrings(true, buf)
+		if err != nil {
+			return err
+		}
+		p.User = append(p.User, UserProperty{K: k, V: v})
+	case PropMaximumPacketSize:
+		p.MaximumPacketSize, err = readUint32Ptr(buf)
+		if err == nil && *p.MaximumPacketSize == 0 {
+			err = xerror.ErrProtocol
+		}
+	case PropWildcardSubAvailable:
+		p.WildcardSubAvailable, err = readBoolByte(buf)
+	case PropSubIDAvailable:
+		p.SubIDAvailable, err = readBoolByte(buf)
+	case PropSharedSubAvailable:
+		p.SharedSubAvailable, err = readBoolByte(buf)
+	}
+	return err
+}
+
+// allowed returns whether the property is allowed in the packet type.
+func allowed(propId byte, packetType Type) bool {
+	_, ok := propertyToPacket[propId][packetType]
+	return ok
+}
+
+// decodeVariableByteInteger reads a Variable Byte Integer from the buffer.
+// Unlike DecodeRemainLength, it returns ErrMalformed if the buffer ends unexpectedly.
+func decodeVariableByteInteger(r *bytes.Buffer) (int, error) {
+	var multiplier = 1
+	var value int
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, xerror.ErrMalformed
+		}
+		value += int(b&127) * multiplier
+		if b&128 == 0 {
+			return value, nil
+		}
+		multiplier *= 128
+	}
+	return 0, xerror.ErrMalformed
+}
+
+func writeUint32(w *bytes.Buffer, value uint32) {
+	w.WriteByte(byte(value >> 24))
+	w.WriteByte(byte(value >> 16))
+	w.WriteByte(byte(value >> 8))
+	w.WriteByte(byte(value))
+}
+
+func readUint32(r *bytes.Buffer) (uint32, error) {
+	if r.Len() < 4 {
+		return 0, xerror.ErrMalformed
+	}
+	return binary.BigEndian.Uint32(r.Next(4)), nil
+}
+
+func readUint32Ptr(r *bytes.Buffer) (*uint32, error) {
+	v, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func readUint16Ptr(r *bytes.Buffer) (*uint16, error) {
+	v, err := readUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// readBoolByte reads a byte which must be 0 or 1.
+func readBoolByte(r *bytes.Buffer) (*byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, xerror.ErrMalformed
+	}
+	if b != 0 && b != 1 {
+		return nil, xerror.ErrProtocol
+	}
+	return &b, nil
+}
@@ -0,0 +1,155 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package packet
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"testing"
+)
+
+func uint16Ptr(v uint16) *uint16 { return &v }
+func uint32Ptr(v uint32) *uint32 { return &v }
+func bytePtr(v byte) *byte       { return &v }
+
+func TestProperties_EncodeDecode(t *testing.T) {
+	tests := []struct {
+		name       string
+		packetType Type
+		props      *Properties
+	}{
+		{
+			name:       "connect",
+			packetType: CONNECT,
+			props: &Properties{
+				SessionExpiryInterval: uint32Ptr(100),
+				AuthMethod:            []byte("SCRAM-SHA-1"),
+				AuthData:              []byte{0x1, 0x2},
+				RequestProblemInfo:    bytePtr(0),
+				RequestResponseInfo:   bytePtr(1),
+				ReceiveMaximum:        uint16Ptr(10),
+				TopicAliasMaximum:     uint16Ptr(5),
+				User:                  []UserProperty{{K: []byte("k1"), V: []byte("v1")}, {K: []byte("k1"), V: []byte("v2")}},
+				MaximumPacketSize:     uint32Ptr(1024),
+			},
+		},
+		{
+			name:       "connack",
+			packetType: CONNACK,
+			props: &Properties{
+				SessionExpiryInterval: uint32Ptr(100),
+				AssignedClientId:      []byte("id"),
+				ServerKeepAlive:       uint16Ptr(60),
+				ResponseInfo:          []byte("info"),
+				ServerReference:       []byte("ref"),
+				ReasonString:          []byte("reason"),
+				ReceiveMaximum:        uint16Ptr(10),
+				TopicAliasMaximum:     uint16Ptr(5),
+				MaximumQoS:            bytePtr(1),
+				RetainAvailable:       bytePtr(1),
+				MaximumPacketSize:     uint32Ptr(1024),
+				WildcardSubAvailable:  bytePtr(0),
+				SubIDAvailable:        bytePtr(1),
+				SharedSubAvailable:    bytePtr(1),
+			},
+		},
+		{
+			name:       "publish",
+			packetType: PUBLISH,
+			props: &Properties{
+				PayloadFormat:          bytePtr(PayloadFormatString),
+				MessageExpiry:          uint32Ptr(10),
+				ContentType:            []byte("json"),
+				ResponseTopic:          []byte("a/b"),
+				CorrelationData:        []byte{0xff},
+				SubscriptionIdentifier: []uint32{1, 268435455},
+				TopicAlias:             uint16Ptr(1),
+			},
+		},
+		{
+			name:       "will",
+			packetType: willProperties,
+			props: &Properties{
+				WillDelayInterval: uint32Ptr(5),
+				MessageExpiry:     uint32Ptr(10),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.NoError(t, tt.props.Encode(buf, tt.packetType))
+			got := &Properties{}
+			assert.NoError(t, got.Decode(buf, tt.packetType))
+			assert.Equal(t, tt.props, got)
+			assert.Zero(t, buf.Len())
+		})
+	}
+}
+
+func TestProperties_Encode(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		var p *Properties
+		assert.NoError(t, p.Encode(buf, PUBACK))
+		assert.Equal(t, []byte{0}, buf.Bytes())
+	})
+	t.Run("not allowed property is ignored", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		p := &Properties{TopicAlias: uint16Ptr(1), ReasonString: []byte("a")}
+		assert.NoError(t, p.Encode(buf, PUBACK))
+		assert.Equal(t, []byte{4, PropReasonString, 0, 1, 'a'}, buf.Bytes())
+	})
+}
+
+func TestProperties_Decode(t *testing.T) {
+	tests := []struct {
+		name       string
+		packetType Type
+		b          []byte
+		err        error
+	}{
+		{name: "empty", packetType: PUBACK, b: []byte{0}},
+		{name: "not allowed", packetType: PUBACK, b: []byte{3, PropTopicAlias, 0, 1}, err: xerror.ErrProtocol},
+		{name: "duplicated", packetType: CONNECT, b: []byte{6, PropReceiveMaximum, 0, 1, PropReceiveMaximum, 0, 1}, err: xerror.ErrProtocol},
+		{name: "duplicated subscription identifier in subscribe", packetType: SUBSCRIBE, b: []byte{4, PropSubscriptionIdentifier, 1, PropSubscriptionIdentifier, 2}, err: xerror.ErrProtocol},
+		{name: "duplicated subscription identifier in publish", packetType: PUBLISH, b: []byte{4, PropSubscriptionIdentifier, 1, PropSubscriptionIdentifier, 2}},
+		{name: "duplicated user property", packetType: PUBACK, b: []byte{11, PropUser, 0, 1, 'k', 0, 0, PropUser, 0, 0, 0, 0}},
+		{name: "unknown property", packetType: PUBLISH, b: []byte{2, 0x7f, 0}, err: xerror.ErrMalformed},
+		{name: "length overrun", packetType: PUBLISH, b: []byte{5, PropTopicAlias, 0, 1}, err: xerror.ErrMalformed},
+		{name: "truncated value", packetType: PUBLISH, b: []byte{2, PropTopicAlias, 0}, err: xerror.ErrMalformed},
+		{name: "invalid variable byte integer", packetType: PUBLISH, b: []byte{0xff, 0xff, 0xff, 0xff}, err: xerror.ErrMalformed},
+		{name: "zero receive maximum", packetType: CONNECT, b: []byte{3, PropReceiveMaximum, 0, 0}, err: xerror.ErrProtocol},
+		{name: "zero topic alias", packetType: PUBLISH, b: []byte{3, PropTopicAlias, 0, 0}, err: xerror.ErrProtocol},
+		{name: "zero maximum packet size", packetType: CONNECT, b: []byte{5, PropMaximumPacketSize, 0, 0, 0, 0}, err: xerror.ErrProtocol},
+		{name: "zero subscription identifier", packetType: SUBSCRIBE, b: []byte{2, PropSubscriptionIdentifier, 0}, err: xerror.ErrProtocol},
+		{name: "invalid payload format", packetType: PUBLISH, b: []byte{2, PropPayloadFormat, 2}, err: xerror.ErrProtocol},
+		{name: "invalid request problem info", packetType: CONNECT, b: []byte{2, PropRequestProblemInfo, 2}, err: xerror.ErrProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Properties{}
+			err := p.Decode(bytes.NewBuffer(tt.b), tt.packetType)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ package packet
 
 import (
 	"bytes"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -42,3 +43,42 @@ func (bp *BasePub) decode(r io.Reader) (err error) {
 	return
 
 }
+
+// encodeAck writes the variable header of PUBACK, PUBREC, PUBREL and PUBCOMP into the buffer.
+// In MQTT v5, the Reason Code and the Properties can be omitted if the Reason Code is 0x00 (Success) and there are no Properties.
+func encodeAck(buf *bytes.Buffer, version Version, packetType Type, packetId Id, cd code.Code, properties *Properties) error {
+	writeUint16(buf, packetId)
+	if !IsVersion5(version) || (cd == code.Success && properties == nil) {
+		return nil
+	}
+	buf.WriteByte(cd)
+	if properties == nil {
+		// The Property Length can be omitted if there are no Properties.
+		return nil
+	}
+	return properties.Encode(buf, packetType)
+}
+
+// decodeAck reads the variable header of PUBACK, PUBREC, PUBREL and PUBCOMP from the buffer.
+func decodeAck(buf *bytes.Buffer, version Version, packetType Type) (packetId Id, cd code.Code, properties *Properties, err error) {
+	packetId, err = readUint16(buf)
+	if err != nil {
+		return
+	}
+	if !IsVersion5(version) || buf.Len() == 0 {
+		// The Reason Code 0x00 (Success) is used if there is no Reason Code.
+		return
+	}
+	cd, err = buf.ReadByte()
+	if err != nil {
+		err = xerror.ErrMalformed
+		return
+	}
+	if buf.Len() == 0 {
+		// If the Remaining Length is less than 4 there is no Property Length and the value of 0 is used.
+		return
+	}
+	properties = &Properties{}
+	err = properties.Decode(buf, packetType)
+	return
+}
@@ -19,19 +19,20 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
 
-var (
-	pubackDefaultFixedHeader = &FixedHeader{PacketType: PUBACK, Flags: FixedHeaderFlagReserved}
-)
-
 type (
 	Puback struct {
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the Reason Code of MQTT v5.
+		Code code.Code
+		// Properties is the PUBACK Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -49,9 +50,11 @@ func NewPuback(fixedHeader *FixedHeader, version Version, r io.Reader) (*Puback,
 }
 
 func (bp *Puback) Encode(w io.Writer) (err error) {
-	bp.FixedHeader = pubackDefaultFixedHeader
+	bp.FixedHeader = &FixedHeader{PacketType: PUBACK, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
-	writeUint16(buf, bp.PacketId)
+	if err = encodeAck(buf, bp.Version, PUBACK, bp.PacketId, bp.Code, bp.Properties); err != nil {
+		return err
+	}
 	return encode(bp.FixedHeader, buf, w)
 }
 
@@ -62,10 +65,7 @@ func (bp *Puback) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	buf := bytes.NewBuffer(b)
-	bp.PacketId, err = readUint16(buf)
-	if err != nil {
-		return
-	}
+	bp.PacketId, bp.Code, bp.Properties, err = decodeAck(buf, bp.Version, PUBACK)
 	return
 }
 
@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -28,6 +29,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the Reason Code of MQTT v5.
+		Code code.Code
+		// Properties is the PUBCOMP Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -46,7 +51,9 @@ func NewPubcomp(fixedHeader *FixedHeader, version Version, r io.Reader) (*Pubcom
 func (pb *Pubcomp) Encode(w io.Writer) (err error) {
 	pb.FixedHeader = &FixedHeader{PacketType: PUBCOMP, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
-	writeUint16(buf, pb.PacketId)
+	if err = encodeAck(buf, pb.Version, PUBCOMP, pb.PacketId, pb.Code, pb.Properties); err != nil {
+		return err
+	}
 	return encode(pb.FixedHeader, buf, w)
 }
 
@@ -57,10 +64,7 @@ func (pb *Pubcomp) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	buf := bytes.NewBuffer(b)
-	pb.PacketId, err = readUint16(buf)
-	if err != nil {
-		return
-	}
+	pb.PacketId, pb.Code, pb.Properties, err = decodeAck(buf, pb.Version, PUBCOMP)
 	return
 }
 
@@ -39,6 +39,8 @@ type (
 		TopicName   []byte //主题名
 		PacketId    Id     //报文标识符
 		Payload     []byte
+		// Properties is the PUBLISH Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -79,6 +81,11 @@ func (p *Publish) Encode(w io.Writer) (err error) {
 	if p.QoS == QoS1 || p.QoS == QoS2 {
 		writeUint16(buf, p.PacketId)
 	}
+	if IsVersion5(p.Version) {
+		if err = p.Properties.Encode(buf, PUBLISH); err != nil {
+			return err
+		}
+	}
 	buf.Write(p.Payload)
 
 	// 写入
@@ -96,9 +103,6 @@ func (p *Publish) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
-	if !ValidTopicName(true, p.TopicName) {
-		return xerror.ErrMalformed
-	}
 
 	if p.QoS > QoS0 {
 		// The Packet Identifier field is only present in PUBLISH Packets where the QoS level is 1 or 2.
@@ -107,13 +111,31 @@ func (p *Publish) Decode(r io.Reader) (err error) {
 			return
 		}
 	}
+	if IsVersion5(p.Version) {
+		p.Properties = &Properties{}
+		if err = p.Properties.Decode(buf, PUBLISH); err != nil {
+			return err
+		}
+		// A zero length Topic Name is only allowed when the Topic Alias is present.
+		if len(p.TopicName) == 0 && p.Properties.TopicAlias != nil {
+			p.Payload = buf.Next(buf.Len())
+			return nil
+		}
+	}
+	if !ValidTopicName(true, p.TopicName) {
+		return xerror.ErrMalformed
+	}
 	p.Payload = buf.Next(buf.Len())
 	return nil
 }
 
 func (p *Publish) String() string {
-	return fmt.Sprintf("Publish - Version: %v, PacketId: %v, Dup: %v, Qos: %v, Retain: %v, TopicName: %s, Payload: %s",
+	s := fmt.Sprintf("Publish - Version: %v, PacketId: %v, Dup: %v, Qos: %v, Retain: %v, TopicName: %s, Payload: %s",
 		p.Version, p.PacketId, p.Dup, p.QoS, p.Retain, p.TopicName, p.Payload)
+	if IsVersion5(p.Version) {
+		s += fmt.Sprintf(", Properties: %s", p.Properties)
+	}
+	return s
 }
 
 // CreatePuback returns the puback struct related to the publish struct in QoS 1
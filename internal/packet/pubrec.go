@@ -19,19 +19,20 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
 
-var (
-	pubrecDefaultFixedHeader = &FixedHeader{PacketType: PUBREC, Flags: FixedHeaderFlagReserved}
-)
-
 type (
 	Pubrec struct {
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the Reason Code of MQTT v5.
+		Code code.Code
+		// Properties is the PUBREC Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -48,9 +49,11 @@ func NewPubrec(fixedHeader *FixedHeader, version Version, r io.Reader) (*Pubrec,
 }
 
 func (p *Pubrec) Encode(w io.Writer) (err error) {
-	p.FixedHeader = pubrecDefaultFixedHeader
+	p.FixedHeader = &FixedHeader{PacketType: PUBREC, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
-	writeUint16(buf, p.PacketId)
+	if err = encodeAck(buf, p.Version, PUBREC, p.PacketId, p.Code, p.Properties); err != nil {
+		return err
+	}
 	return encode(p.FixedHeader, buf, w)
 }
 
@@ -61,12 +64,8 @@ func (p *Pubrec) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	buf := bytes.NewBuffer(b)
-	p.PacketId, err = readUint16(buf)
-	if err != nil {
-		return
-	}
+	p.PacketId, p.Code, p.Properties, err = decodeAck(buf, p.Version, PUBREC)
 	return
-
 }
 
 func (p *Pubrec) String() string {
@@ -76,6 +75,7 @@ func (p *Pubrec) String() string {
 // CreateNewPubrel returns the Pubrel struct related to the Pubrec struct in QoS 2.
 func (p *Pubrec) CreateNewPubrel() *Pubrel {
 	pub := &Pubrel{
+		Version:     p.Version,
 		FixedHeader: &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagPubrel, RemainLength: 2},
 		PacketId:    p.PacketId,
 	}
@@ -19,6 +19,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"io"
 )
@@ -28,6 +29,10 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
+		// Code is the Reason Code of MQTT v5.
+		Code code.Code
+		// Properties is the PUBREL Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -44,9 +49,11 @@ func NewPubrel(fixedHeader *FixedHeader, version Version, r io.Reader) (*Pubrel,
 	return p, nil
 }
 func (p *Pubrel) Encode(w io.Writer) (err error) {
-	p.FixedHeader = &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagReserved}
+	p.FixedHeader = &FixedHeader{PacketType: PUBREL, Flags: FixedHeaderFlagPubrel}
 	buf := &bytes.Buffer{}
-	writeUint16(buf, p.PacketId)
+	if err = encodeAck(buf, p.Version, PUBREL, p.PacketId, p.Code, p.Properties); err != nil {
+		return err
+	}
 	return encode(p.FixedHeader, buf, w)
 }
 
@@ -57,7 +64,7 @@ func (p *Pubrel) Decode(r io.Reader) (err error) {
 		return xerror.ErrMalformed
 	}
 	buf := bytes.NewBuffer(b)
-	p.PacketId, err = readUint16(buf)
+	p.PacketId, p.Code, p.Properties, err = decodeAck(buf, p.Version, PUBREL)
 	return
 }
 
@@ -24,14 +24,14 @@ import (
 	"io"
 )
 
-var subackDefaultFixedHeader = &FixedHeader{PacketType: SUBACK, Flags: FixedHeaderFlagReserved}
-
 type (
 	Suback struct {
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
 		Payload     []code.Code
+		// Properties is the SUBACK Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -46,9 +46,14 @@ func NewSuback(fixedHeader *FixedHeader, version Version, r io.Reader) (*Suback,
 	return p, err
 }
 func (s *Suback) Encode(w io.Writer) (err error) {
-	s.FixedHeader = subackDefaultFixedHeader
+	s.FixedHeader = &FixedHeader{PacketType: SUBACK, Flags: FixedHeaderFlagReserved}
 	bufw := &bytes.Buffer{}
 	writeUint16(bufw, s.PacketId)
+	if IsVersion5(s.Version) {
+		if err = s.Properties.Encode(bufw, SUBACK); err != nil {
+			return err
+		}
+	}
 
 	bufw.Write(s.Payload)
 	return encode(s.FixedHeader, bufw, w)
@@ -66,6 +71,12 @@ func (s *Suback) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return xerror.ErrMalformed
 	}
+	if IsVersion5(s.Version) {
+		s.Properties = &Properties{}
+		if err = s.Properties.Decode(buf, SUBACK); err != nil {
+			return err
+		}
+	}
 
 	for buf.Len() != 0 {
 		b, err := buf.ReadByte()
@@ -78,5 +89,9 @@ func (s *Suback) Decode(r io.Reader) (err error) {
 }
 
 func (s *Suback) String() string {
-	return fmt.Sprintf("Suback - Versoin: %s, PacketId: %d", s.Version, s.PacketId)
+	str := fmt.Sprintf("Suback - Versoin: %s, PacketId: %d", s.Version, s.PacketId)
+	if IsVersion5(s.Version) {
+		str += fmt.Sprintf(", Properties: %s", s.Properties)
+	}
+	return str
 }
@@ -29,6 +29,8 @@ type (
 		FixedHeader *FixedHeader
 		PacketId    Id
 		Topics      []*Topic //suback响应之前填充
+		// Properties is the SUBSCRIBE Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -49,11 +51,26 @@ func (s *Subscribe) Encode(w io.Writer) (err error) {
 	s.FixedHeader = &FixedHeader{PacketType: SUBSCRIBE, Flags: FixedHeaderFlagSubscribe}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, s.PacketId)
+	if IsVersion5(s.Version) {
+		if err = s.Properties.Encode(buf, SUBSCRIBE); err != nil {
+			return err
+		}
+	}
 
 	// payload
 	for _, t := range s.Topics {
 		writeBinary(buf, []byte(t.Name))
-		buf.WriteByte(t.QoS)
+		var opts = t.QoS
+		if IsVersion5(s.Version) {
+			if t.NoLocal {
+				opts |= 1 << 2
+			}
+			if t.RetainAsPublished {
+				opts |= 1 << 3
+			}
+			opts |= t.RetainHandling << 4
+		}
+		buf.WriteByte(opts)
 	}
 	return encode(s.FixedHeader, buf, w)
 }
@@ -70,13 +87,23 @@ func (s *Subscribe) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return err
 	}
+	if IsVersion5(s.Version) {
+		s.Properties = &Properties{}
+		if err = s.Properties.Decode(bufr, SUBSCRIBE); err != nil {
+			return err
+		}
+	}
 	// topics
 	for bufr.Len() != 0 {
 		topicFilter, err := UTF8DecodedStrings(true, bufr)
 		if err != nil {
 			return err
 		}
-		if !ValidTopicFilter(true, topicFilter) {
+		if IsVersion5(s.Version) {
+			if !ValidV5Topic(topicFilter) {
+				return xerror.ErrMalformed
+			}
+		} else if !ValidTopicFilter(true, topicFilter) {
 			return xerror.ErrMalformed
 		}
 		topicOpts, err := bufr.ReadByte()
@@ -86,7 +113,25 @@ func (s *Subscribe) Decode(r io.Reader) (err error) {
 		topic := &Topic{
 			Name: string(topicFilter),
 		}
-		topic.QoS = topicOpts
+		if IsVersion5(s.Version) {
+			// Bits 6 and 7 of the Subscription Options byte are reserved for future use. [MQTT-3.8.3-5]
+			if topicOpts&0xC0 != 0 {
+				return xerror.ErrMalformed
+			}
+			topic.NoLocal = (1 & (topicOpts >> 2)) > 0
+			topic.RetainAsPublished = (1 & (topicOpts >> 3)) > 0
+			topic.RetainHandling = 3 & (topicOpts >> 4)
+			if topic.RetainHandling == 3 {
+				return xerror.ErrProtocol
+			}
+			// It is a Protocol Error to set the No Local bit to 1 on a Shared Subscription. [MQTT-3.8.3-4]
+			if topic.NoLocal && bytes.HasPrefix(topicFilter, []byte("$share/")) {
+				return xerror.ErrProtocol
+			}
+		} else if topicOpts&0xFC != 0 { // [MQTT-3-8.3-4]
+			return xerror.ErrMalformed
+		}
+		topic.QoS = 3 & topicOpts
 		if topic.QoS > QoS2 {
 			return xerror.ErrProtocol
 		}
@@ -97,5 +142,9 @@ func (s *Subscribe) Decode(r io.Reader) (err error) {
 }
 
 func (s *Subscribe) String() string {
-	return fmt.Sprintf("Subscribe - Versioin: %s,PacketId: %d, Topics: %v", s.Version, s.PacketId, s.Topics)
+	str := fmt.Sprintf("Subscribe - Versioin: %s,PacketId: %d, Topics: %v", s.Version, s.PacketId, s.Topics)
+	if IsVersion5(s.Version) {
+		str += fmt.Sprintf(", Properties: %s", s.Properties)
+	}
+	return str
 }
@@ -24,6 +24,16 @@ import (
 
 const TopicMaxLen = 65535
 
+// Retain Handling option of the subscription.
+const (
+	// RetainHandlingSendAtSubscribe sends retained messages at the time of the subscribe.
+	RetainHandlingSendAtSubscribe byte = iota
+	// RetainHandlingSendAtSubscribeIfNotExist sends retained messages at subscribe only if the subscription does not currently exist.
+	RetainHandlingSendAtSubscribeIfNotExist
+	// RetainHandlingDoNotSend does not send retained messages at the time of the subscribe.
+	RetainHandlingDoNotSend
+)
+
 type (
 	// Topic represents the MQTT Topic
 	Topic struct {
@@ -29,17 +29,37 @@ type (
 		Version     Version
 		FixedHeader *FixedHeader
 		PacketId    Id
-		Payload     []code.Code
+		// Payload is the Reason Codes of MQTT v5, it is absent in MQTT v3.
+		Payload []code.Code
+		// Properties is the UNSUBACK Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
+// NewUnsuback returns a Unsuback instance by the given FixHeader and io.Reader.
+func NewUnsuback(fixedHeader *FixedHeader, version Version, r io.Reader) (*Unsuback, error) {
+	p := &Unsuback{FixedHeader: fixedHeader, Version: version}
+	if fixedHeader.Flags != FixedHeaderFlagReserved {
+		return nil, xerror.ErrMalformed
+	}
+	err := p.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 func (u *Unsuback) Encode(w io.Writer) (err error) {
 	u.FixedHeader = &FixedHeader{PacketType: UNSUBACK, Flags: FixedHeaderFlagReserved}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, u.PacketId)
-
-	// payload
-	buf.Write(u.Payload)
+	if IsVersion5(u.Version) {
+		if err = u.Properties.Encode(buf, UNSUBACK); err != nil {
+			return err
+		}
+		// payload
+		buf.Write(u.Payload)
+	}
 
 	return encode(u.FixedHeader, buf, w)
 }
@@ -55,6 +75,13 @@ func (u *Unsuback) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	if IsVersion5(u.Version) {
+		u.Properties = &Properties{}
+		if err = u.Properties.Decode(buf, UNSUBACK); err != nil {
+			return err
+		}
+		u.Payload = buf.Next(buf.Len())
+	}
 	return nil
 }
 
@@ -29,6 +29,8 @@ type (
 		FixedHeader *FixedHeader
 		PacketId    Id
 		Topics      []string
+		// Properties is the UNSUBSCRIBE Properties of MQTT v5.
+		Properties *Properties
 	}
 )
 
@@ -50,6 +52,11 @@ func (u *Unsubscribe) Encode(w io.Writer) (err error) {
 	u.FixedHeader = &FixedHeader{PacketType: UNSUBSCRIBE, Flags: FixedHeaderFlagUnsubscribe}
 	buf := &bytes.Buffer{}
 	writeUint16(buf, u.PacketId)
+	if IsVersion5(u.Version) {
+		if err = u.Properties.Encode(buf, UNSUBSCRIBE); err != nil {
+			return err
+		}
+	}
 	for _, topic := range u.Topics {
 		writeBinary(buf, []byte(topic))
 	}
@@ -67,6 +74,12 @@ func (u *Unsubscribe) Decode(r io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	if IsVersion5(u.Version) {
+		u.Properties = &Properties{}
+		if err = u.Properties.Decode(bufr, UNSUBSCRIBE); err != nil {
+			return err
+		}
+	}
 	// topics
 	for bufr.Len() != 0 {
 		topicFilter, err := UTF8DecodedStrings(true, bufr)
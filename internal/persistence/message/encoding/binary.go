@@ -66,6 +66,11 @@ func EncodeMessage(msg *message.Message, w *bytes.Buffer) {
 		l, _ := packet.EncodeRemainLength(int(v))
 		_, _ = w.Write(l)
 	}
+	for _, v := range msg.UserProperties {
+		_ = w.WriteByte(packet.PropUser)
+		_ = xbinary.WriteBytes(w, v.K)
+		_ = xbinary.WriteBytes(w, v.V)
+	}
 }
 
 func DecodeMessage(r *bytes.Reader) (*message.Message, error) {
@@ -139,6 +144,16 @@ func DecodeMessage(r *bytes.Reader) (*message.Message, error) {
 				return nil, err
 			}
 			msg.SubscriptionIdentifier = append(msg.SubscriptionIdentifier, uint32(si))
+		case packet.PropUser:
+			k, err := xbinary.ReadBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := xbinary.ReadBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			msg.UserProperties = append(msg.UserProperties, packet.UserProperty{K: k, V: v})
 		}
 	}
 
@@ -38,6 +38,7 @@ func TestEncodeMessage(t *testing.T) {
 		PayloadFormat:          packet.PayloadFormatBytes,
 		ResponseTopic:          "",
 		SubscriptionIdentifier: []uint32{1, 2},
+		UserProperties:         []packet.UserProperty{{K: []byte("k"), V: []byte("v")}},
 	}
 	buffer := &bytes.Buffer{}
 	EncodeMessage(m, buffer)
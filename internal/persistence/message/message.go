@@ -36,6 +36,7 @@ type (
 		PayloadFormat          packet.PayloadFormat
 		ResponseTopic          string
 		SubscriptionIdentifier []uint32
+		UserProperties         []packet.UserProperty
 	}
 )
 
@@ -46,13 +47,27 @@ func (m *Message) String() string {
 }
 
 func FromPublish(publish *packet.Publish) *Message {
-	return &Message{
+	msg := &Message{
 		Dup:      publish.Dup,
 		QoS:      publish.QoS,
 		Retained: publish.Retain,
 		Topic:    string(publish.TopicName),
 		Payload:  publish.Payload,
+		PacketId: publish.PacketId,
+	}
+	if props := publish.Properties; props != nil && packet.IsVersion5(publish.Version) {
+		msg.ContentType = string(props.ContentType)
+		msg.CorrelationData = props.CorrelationData
+		if props.MessageExpiry != nil {
+			msg.MessageExpiry = *props.MessageExpiry
+		}
+		if props.PayloadFormat != nil {
+			msg.PayloadFormat = *props.PayloadFormat
+		}
+		msg.ResponseTopic = string(props.ResponseTopic)
+		msg.UserProperties = props.User
 	}
+	return msg
 }
 
 // TotalBytes return the publish packets total bytes.
@@ -84,6 +99,9 @@ func (m *Message) TotalBytes(version packet.Version) uint32 {
 		if l := len(m.ResponseTopic); l != 0 {
 			propertyLenght += 3 + l
 		}
+		for _, v := range m.UserProperties {
+			propertyLenght += 5 + len(v.K) + len(v.V)
+		}
 
 		remainLenght += propertyLenght + getVariableLength(propertyLenght)
 	}
@@ -111,6 +129,7 @@ func (m *Message) Copy() *Message {
 		PayloadFormat:          m.PayloadFormat,
 		ResponseTopic:          m.ResponseTopic,
 		SubscriptionIdentifier: m.SubscriptionIdentifier,
+		UserProperties:         m.UserProperties,
 	}
 }
 func getVariableLength(l int) int {
@@ -137,6 +156,25 @@ func ToPublish(msg *Message, version packet.Version) *packet.Publish {
 		Payload:   msg.Payload,
 		Version:   version,
 	}
+	if packet.IsVersion5(version) {
+		pub.Properties = &packet.Properties{
+			CorrelationData:        msg.CorrelationData,
+			SubscriptionIdentifier: msg.SubscriptionIdentifier,
+			User:                   msg.UserProperties,
+		}
+		if msg.ContentType != "" {
+			pub.Properties.ContentType = []byte(msg.ContentType)
+		}
+		if msg.MessageExpiry != 0 {
+			pub.Properties.MessageExpiry = &msg.MessageExpiry
+		}
+		if msg.PayloadFormat == packet.PayloadFormatString {
+			pub.Properties.PayloadFormat = &msg.PayloadFormat
+		}
+		if msg.ResponseTopic != "" {
+			pub.Properties.ResponseTopic = []byte(msg.ResponseTopic)
+		}
+	}
 
 	return pub
 }
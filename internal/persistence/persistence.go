@@ -17,8 +17,11 @@
 package persistence
 
 import (
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/retained"
 	"github.com/yunqi/lighthouse/internal/persistence/session"
 	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
 )
 
 const (
@@ -29,6 +32,9 @@ const (
 var (
 	sessionStores      = map[string]session.NewStore{}
 	subscriptionStores = map[string]subscription.NewStore{}
+	queueStores        = map[string]queue.NewStore{}
+	retainedStores     = map[string]retained.NewStore{}
+	unackStores        = map[string]unack.NewStore{}
 )
 
 func RegisterSessionStore(name string, store session.NewStore) {
@@ -47,3 +53,30 @@ func GetSubscriptionStore(name string) (store subscription.NewStore, ok bool) {
 	s, ok := subscriptionStores[name]
 	return s, ok
 }
+
+func RegisterQueueStore(name string, store queue.NewStore) {
+	queueStores[name] = store
+}
+
+func GetQueueStore(name string) (store queue.NewStore, ok bool) {
+	s, ok := queueStores[name]
+	return s, ok
+}
+
+func RegisterRetainedStore(name string, store retained.NewStore) {
+	retainedStores[name] = store
+}
+
+func GetRetainedStore(name string) (store retained.NewStore, ok bool) {
+	s, ok := retainedStores[name]
+	return s, ok
+}
+
+func RegisterUnackStore(name string, store unack.NewStore) {
+	unackStores[name] = store
+}
+
+func GetUnackStore(name string) (store unack.NewStore, ok bool) {
+	s, ok := unackStores[name]
+	return s, ok
+}
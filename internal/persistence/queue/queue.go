@@ -2,11 +2,27 @@ package queue
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
 	"io"
 	"time"
 )
 
+// NewStore creates the Queue of the client specified by opts.
+type NewStore func(config *config.StoreType, opts *Options) (Queue, error)
+
+// Options is used to pass the client information to NewStore.
+type Options struct {
+	// ClientId is the client id of the queue owner.
+	ClientId string
+	// MaxQueuedMsg is the maximum queue length.
+	MaxQueuedMsg int
+	// InflightExpiry is the lifetime of the inflight message.
+	InflightExpiry time.Duration
+	// DefaultNotifier is the Notifier used before Init is called.
+	DefaultNotifier Notifier
+}
+
 // InitOptions is used to pass some required client information to the queue.Init()
 type InitOptions struct {
 	// CleanStart is the cleanStart field in the connect packet.
@@ -3,8 +3,10 @@ package redis
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/queue"
 	red "github.com/yunqi/lighthouse/internal/redis"
 	"github.com/yunqi/lighthouse/internal/xerror"
@@ -20,6 +22,10 @@ const (
 
 var _ queue.Queue = (*Queue)(nil)
 
+func init() {
+	persistence.RegisterQueueStore(persistence.Redis, NewStore())
+}
+
 func getKey(clientID string) string {
 	return queuePrefix + clientID
 }
@@ -72,6 +78,26 @@ func New(opts Options) (*Queue, error) {
 	}, nil
 }
 
+// NewStore returns a queue.NewStore which creates redis queues.
+func NewStore() queue.NewStore {
+	return func(config *config.StoreType, opts *queue.Options) (queue.Queue, error) {
+		var redisOpts []red.Option
+		switch config.Redis.Type {
+		case red.NodeType:
+			redisOpts = append(redisOpts, red.WithNodeType())
+		case red.ClusterType:
+			redisOpts = append(redisOpts, red.WithClusterType())
+		}
+		return New(Options{
+			MaxQueuedMsg:    opts.MaxQueuedMsg,
+			ClientID:        opts.ClientId,
+			InflightExpiry:  opts.InflightExpiry,
+			DefaultNotifier: opts.DefaultNotifier,
+			Redis:           red.New(config.Redis.Addr, redisOpts...),
+		})
+	}
+}
+
 func wrapError(err error) *xerror.Error {
 	return &xerror.Error{
 		Code: code.UnspecifiedError,
@@ -89,7 +115,8 @@ func (q *Queue) Close() error {
 		q.cond.Signal()
 	}()
 	q.closed = true
-	return nil
+	// 释放共享的 redis 客户端，再次 Init 时重新持有
+	return q.r.Close()
 }
 
 func (q *Queue) setLen(ctx context.Context) error {
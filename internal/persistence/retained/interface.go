@@ -1,9 +1,13 @@
 package retained
 
 import (
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/persistence/message"
 )
 
+// NewStore creates a retained message Store.
+type NewStore func(config *config.StoreType) (Store, error)
+
 // IterateFn is the callback function used by iterate()
 // Return false means to stop the iteration.
 type IterateFn func(message *message.Message) bool
@@ -31,4 +35,6 @@ type Store interface {
 	// This method will walk through all retained messages,
 	// so this will be a expensive operation if there are a large number of retained messages.
 	Iterate(fn IterateFn)
+	// Close will be called when the server is stopped.
+	Close() error
 }
@@ -0,0 +1,140 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/message/encoding"
+	"github.com/yunqi/lighthouse/internal/persistence/retained"
+	"github.com/yunqi/lighthouse/internal/persistence/retained/trie"
+	red "github.com/yunqi/lighthouse/internal/redis"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"go.uber.org/zap"
+	"sync"
+)
+
+const (
+	// retainedKey is the redis hash which stores all retained messages, the field is the topic name.
+	retainedKey = "lighthouse:retained"
+)
+
+var _ retained.Store = (*Store)(nil)
+
+func init() {
+	persistence.RegisterRetainedStore(persistence.Redis, New())
+}
+
+// Store stores the retained messages in redis and keeps the in-memory trie as the read index.
+type Store struct {
+	mu       sync.Mutex
+	memStore retained.Store
+	r        *red.Redis
+	log      *xlog.Log
+}
+
+func New() retained.NewStore {
+	return func(config *config.StoreType) (retained.Store, error) {
+		var opts []red.Option
+		switch config.Redis.Type {
+		case red.NodeType:
+			opts = append(opts, red.WithNodeType())
+		case red.ClusterType:
+			opts = append(opts, red.WithClusterType())
+		}
+		s := &Store{
+			memStore: trie.NewStore(),
+			r:        red.New(config.Redis.Addr, opts...),
+			log:      xlog.LoggerModule("retained"),
+		}
+		if err := s.init(context.Background()); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// init loads all retained messages from redis into memory.
+func (s *Store) init(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs, err := s.r.Hgetall(ctx, retainedKey)
+	if err != nil {
+		return err
+	}
+	for _, value := range rs {
+		msg, err := encoding.DecodeMessageFromBytes([]byte(value))
+		if err != nil {
+			return err
+		}
+		s.memStore.AddOrReplace(msg)
+	}
+	return nil
+}
+
+func (s *Store) GetRetainedMessage(topicName string) *message.Message {
+	return s.memStore.GetRetainedMessage(topicName)
+}
+
+func (s *Store) ClearAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.r.Del(context.Background(), retainedKey); err != nil {
+		s.log.Error("clear retained messages", zap.Error(err))
+		return
+	}
+	s.memStore.ClearAll()
+}
+
+func (s *Store) AddOrReplace(message *message.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &bytes.Buffer{}
+	encoding.EncodeMessage(message, b)
+	if err := s.r.Hset(context.Background(), retainedKey, message.Topic, b.Bytes()); err != nil {
+		s.log.Error("add retained message", zap.String("topic", message.Topic), zap.Error(err))
+		return
+	}
+	s.memStore.AddOrReplace(message)
+}
+
+func (s *Store) Remove(topicName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.r.Hdel(context.Background(), retainedKey, topicName); err != nil {
+		s.log.Error("remove retained message", zap.String("topic", topicName), zap.Error(err))
+		return
+	}
+	s.memStore.Remove(topicName)
+}
+
+func (s *Store) GetMatchedMessages(topicFilter string) []*message.Message {
+	return s.memStore.GetMatchedMessages(topicFilter)
+}
+
+func (s *Store) Iterate(fn retained.IterateFn) {
+	s.memStore.Iterate(fn)
+}
+
+// Close releases the shared redis client.
+func (s *Store) Close() error {
+	_ = s.memStore.Close()
+	return s.r.Close()
+}
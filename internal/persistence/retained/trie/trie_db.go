@@ -1,11 +1,24 @@
 package trie
 
 import (
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/message"
 	"github.com/yunqi/lighthouse/internal/persistence/retained"
 	"sync"
 )
 
+func init() {
+	persistence.RegisterRetainedStore(persistence.Memory, New())
+}
+
+// New returns the retained.NewStore of the in-memory trie.
+func New() retained.NewStore {
+	return func(config *config.StoreType) (retained.Store, error) {
+		return NewStore(), nil
+	}
+}
+
 // trieDB implement the retain.Store, it use trie tree  to store retain messages .
 type trieDB struct {
 	sync.RWMutex
@@ -76,3 +89,8 @@ func (t *trieDB) GetMatchedMessages(topicFilter string) []*message.Message {
 	defer t.RUnlock()
 	return t.getTrie(topicFilter).getMatchedMessages(topicFilter)
 }
+
+// Close closes the store, there is nothing to release.
+func (t *trieDB) Close() error {
+	return nil
+}
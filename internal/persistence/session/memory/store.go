@@ -73,3 +73,7 @@ func (s *Store) Iterate(ctx context.Context, fn session.IterateFn) error {
 	})
 	return nil
 }
+
+func (s *Store) Close() error {
+	return nil
+}
@@ -59,6 +59,7 @@ func (s *Store) Set(ctx context.Context, session *sess.Session) error {
 		"will":                b.Bytes(),
 		"will_delay_interval": session.WillDelayInterval,
 		"connected_at":        session.ConnectedAt.Unix(),
+		"disconnected_at":     session.DisconnectedAt.Unix(),
 		"expiry_interval":     session.ExpiryInterval,
 	})
 
@@ -83,7 +84,7 @@ func (s *Store) Get(ctx context.Context, clientID string) (*sess.Session, error)
 }
 
 func (s *Store) getSessionLocked(ctx context.Context, key string) (*sess.Session, error) {
-	m, err := s.r.Hmget(ctx, key, "client_id", "will", "will_delay_interval", "connected_at", "expiry_interval")
+	m, err := s.r.Hmget(ctx, key, "client_id", "will", "will_delay_interval", "connected_at", "expiry_interval", "disconnected_at")
 	if err != nil {
 		return nil, err
 	}
@@ -116,6 +117,13 @@ func (s *Store) getSessionLocked(ctx context.Context, key string) (*sess.Session
 		}
 		_sess.ExpiryInterval = uint32(parseUint)
 	}
+	if m[5] != nil {
+		parseInt, err := strconv.ParseInt(m[5].(string), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		_sess.DisconnectedAt = time.Unix(parseInt, 0)
+	}
 
 	return _sess, nil
 }
@@ -155,3 +163,7 @@ func (s *Store) Iterate(ctx context.Context, fn session.IterateFn) error {
 
 	return nil
 }
+
+func (s *Store) Close() error {
+	return s.r.Close()
+}
@@ -16,4 +16,6 @@ type Store interface {
 	Get(ctx context.Context, clientID string) (*session.Session, error)
 	Iterate(ctx context.Context, fn IterateFn) error
 	SetSessionExpiry(ctx context.Context, clientID string, expiry uint32) error
+	// Close will be called when the server is stopped.
+	Close() error
 }
@@ -104,12 +104,11 @@ func iterateShared(fn subscription.IterateFn, options subscription.IterationOpti
 	}
 	// 查询指定clientID下的所有topic
 	if options.ClientID != "" {
-		for _, v := range index[options.ClientID] {
-			for _, c := range v.shared {
-				if sub, ok := c[options.ClientID]; ok {
-					if !fn(options.ClientID, sub) {
-						return false
-					}
+		for topic, v := range index[options.ClientID] {
+			shareName, _ := subscription.SplitTopic(topic)
+			if sub, ok := v.shared[shareName][options.ClientID]; ok {
+				if !fn(options.ClientID, sub) {
+					return false
 				}
 			}
 		}
@@ -298,6 +297,8 @@ func (db *TrieDB) SubscribeLocked(ctx context.Context, clientID string, subscrip
 		if sub.ShareName != "" {
 			node = db.sharedTrie.subscribe(clientID, sub)
 			index = db.sharedIndex
+			// 不同共享组可以订阅相同的主题过滤器
+			topicName = subscription.GetFullTopicName(sub.ShareName, sub.TopicFilter)
 		} else if isSystemTopic(topicName) {
 			node = db.systemTrie.subscribe(clientID, sub)
 			index = db.systemIndex
@@ -335,25 +336,27 @@ func (db *TrieDB) Subscribe(ctx context.Context, clientID string, subscriptions
 func (db *TrieDB) UnsubscribeLocked(ctx context.Context, clientID string, topics ...string) {
 	var index map[string]map[string]*topicNode
 	var topicTrie *topicTrie
-	for _, topic := range topics {
-		var shareName string
-		shareName, topic := subscription.SplitTopic(topic)
+	for _, fullTopic := range topics {
+		key := fullTopic
+		shareName, topic := subscription.SplitTopic(fullTopic)
 		if shareName != "" {
 			topicTrie = db.sharedTrie
 			index = db.sharedIndex
 		} else if isSystemTopic(topic) {
 			index = db.systemIndex
 			topicTrie = db.systemTrie
+			key = topic
 		} else {
 			index = db.userIndex
 			topicTrie = db.userTrie
+			key = topic
 		}
 		if _, ok := index[clientID]; ok {
-			if _, ok := index[clientID][topic]; ok {
+			if _, ok := index[clientID][key]; ok {
 				db.stats.SubscriptionsCurrent--
 				db.clientStats[clientID].SubscriptionsCurrent--
 			}
-			delete(index[clientID], topic)
+			delete(index[clientID], key)
 		}
 		topicTrie.unsubscribe(clientID, topic, shareName)
 	}
@@ -367,26 +370,26 @@ func (db *TrieDB) Unsubscribe(ctx context.Context, clientID string, topics ...st
 	return nil
 }
 
-func (db *TrieDB) unsubscribeAll(index map[string]map[string]*topicNode, clientID string) {
+func (db *TrieDB) unsubscribeAll(index map[string]map[string]*topicNode, trie *topicTrie, clientID string) {
 	db.stats.SubscriptionsCurrent -= uint64(len(index[clientID]))
 	if db.clientStats[clientID] != nil {
 		db.clientStats[clientID].SubscriptionsCurrent -= uint64(len(index[clientID]))
 	}
-	for topicName, node := range index[clientID] {
-		delete(node.clients, clientID)
-		if len(node.clients) == 0 && len(node.children) == 0 {
-			ss := strings.Split(topicName, "/")
-			delete(node.parent.children, ss[len(ss)-1])
+	for topicName := range index[clientID] {
+		shareName, topicFilter := subscription.SplitTopic(topicName)
+		if trie != db.sharedTrie {
+			shareName, topicFilter = "", topicName
 		}
+		trie.unsubscribe(clientID, topicFilter, shareName)
 	}
 	delete(index, clientID)
 }
 
 // UnsubscribeAllLocked is the non thread-safe version of UnsubscribeAll
 func (db *TrieDB) UnsubscribeAllLocked(clientID string) {
-	db.unsubscribeAll(db.userIndex, clientID)
-	db.unsubscribeAll(db.systemIndex, clientID)
-	db.unsubscribeAll(db.sharedIndex, clientID)
+	db.unsubscribeAll(db.userIndex, db.userTrie, clientID)
+	db.unsubscribeAll(db.systemIndex, db.systemTrie, clientID)
+	db.unsubscribeAll(db.sharedIndex, db.sharedTrie, clientID)
 }
 
 // UnsubscribeAll delete all subscriptions of the client
@@ -406,3 +409,18 @@ func (db *TrieDB) getMatchedTopicFilter(topicName string) subscription.ClientSub
 	}
 	return db.userTrie.getMatchedTopicFilter(topicName)
 }
+
+// MatchTopicFilter returns the non-shared subscriptions whose topic filter match the given topic filter.
+// If cover is true, the subscription topic filter must match all the topics matched by the given topic filter,
+// e.g. "a/#" covers "a/+/b", otherwise it must match at least one of them, e.g. "a/b/#" overlaps "a/+/b".
+func (db *TrieDB) MatchTopicFilter(topicFilter string, cover bool) subscription.ClientSubscriptions {
+	db.RLock()
+	defer db.RUnlock()
+	rs := make(subscription.ClientSubscriptions)
+	trie := db.userTrie
+	if isSystemTopic(topicFilter) {
+		trie = db.systemTrie
+	}
+	trie.matchTopicFilter(strings.Split(topicFilter, "/"), cover, rs)
+	return rs
+}
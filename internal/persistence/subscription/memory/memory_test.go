@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription/test"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	test.TestSuite(t, func() subscription.Store {
+		return New()
+	})
+}
+
+func TestTrieDB_sharedGroups(t *testing.T) {
+	a := assert.New(t)
+	db := New()
+	ctx := context.Background()
+	for _, name := range []string{"$share/g1/job/+", "$share/g2/job/+"} {
+		_, err := db.Subscribe(ctx, "c1", subscription.FromTopic(packet.Topic{Name: name}, 0))
+		a.NoError(err)
+	}
+	stats, err := db.GetClientStats("c1")
+	a.NoError(err)
+	a.EqualValues(2, stats.SubscriptionsCurrent)
+	a.Len(subscription.GetClientSubscriptions(ctx, db, "c1", subscription.TypeShared), 2)
+	a.Len(subscription.GetTopicMatched(ctx, db, "job/1", subscription.TypeShared)["c1"], 2)
+
+	a.NoError(db.Unsubscribe(ctx, "c1", "$share/g1/job/+"))
+	subs := subscription.GetTopicMatched(ctx, db, "job/1", subscription.TypeShared)["c1"]
+	a.Len(subs, 1)
+	a.Equal("g2", subs[0].ShareName)
+
+	a.NoError(db.UnsubscribeAll(ctx, "c1"))
+	a.Nil(subscription.GetTopicMatched(ctx, db, "job/1", subscription.TypeAll))
+	a.EqualValues(0, db.GetStats().SubscriptionsCurrent)
+}
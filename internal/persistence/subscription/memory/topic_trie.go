@@ -160,6 +160,60 @@ func (t *topicTrie) matchTopic(topicSlice []string, rs subscription.ClientSubscr
 	}
 }
 
+// matchTopicFilter get the topic filters which match the given topic filter levels, and set into rs.
+// If cover is true, the topic filter must match all the topics matched by the given topic filter,
+// otherwise it must match at least one of them.
+func (t *topicTrie) matchTopicFilter(topicSlice []string, cover bool, rs subscription.ClientSubscriptions) {
+	endFlag := len(topicSlice) == 1
+	if cnode := t.children["#"]; cnode != nil {
+		setRs(cnode, rs)
+	}
+	lv := topicSlice[0]
+	if lv == "#" {
+		// "#" 只被 "#" 覆盖，但与当前层级及以下的所有主题过滤器重叠
+		if !cover {
+			setRs(t, rs)
+			t.setAllRs(rs)
+		}
+		return
+	}
+	next := func(cnode *topicNode) {
+		if endFlag {
+			setRs(cnode, rs)
+			if n := cnode.children["#"]; n != nil {
+				setRs(n, rs)
+			}
+		} else {
+			cnode.matchTopicFilter(topicSlice[1:], cover, rs)
+		}
+	}
+	if cnode := t.children["+"]; cnode != nil {
+		next(cnode)
+	}
+	if lv == "+" {
+		// "+" 只被 "+" 和 "#" 覆盖，但与当前层级的所有主题过滤器重叠
+		if !cover {
+			for name, cnode := range t.children {
+				if name != "#" && name != "+" {
+					next(cnode)
+				}
+			}
+		}
+		return
+	}
+	if cnode := t.children[lv]; cnode != nil {
+		next(cnode)
+	}
+}
+
+// setAllRs set the subscription info of all the descendants of the node into rs
+func (t *topicNode) setAllRs(rs subscription.ClientSubscriptions) {
+	for _, cnode := range t.children {
+		setRs(cnode, rs)
+		cnode.setAllRs(rs)
+	}
+}
+
 // getMatchedTopicFilter return a map key by clientID that contain all matched topic for the given topicName.
 func (t *topicTrie) getMatchedTopicFilter(topicName string) subscription.ClientSubscriptions {
 	topicLv := strings.Split(topicName, "/")
@@ -2,7 +2,9 @@ package memory
 
 import (
 	"github.com/yunqi/lighthouse/internal/packet"
+	subscription2 "github.com/yunqi/lighthouse/internal/persistence/subscription"
 	"github.com/yunqi/lighthouse/internal/subscription"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -215,6 +217,46 @@ func TestTopicTrie_matchedClients(t *testing.T) {
 	}
 }
 
+var testTopicFilterMatch = []struct {
+	subTopic    string // subscribe topic
+	topicFilter string // matched topic filter
+	cover       bool
+	overlap     bool
+}{
+	{subTopic: "#", topicFilter: "a/+/b", cover: true, overlap: true},
+	{subTopic: "a/#", topicFilter: "a/#", cover: true, overlap: true},
+	{subTopic: "a/#", topicFilter: "a", cover: true, overlap: true},
+	{subTopic: "a/+", topicFilter: "a/+", cover: true, overlap: true},
+	{subTopic: "a/+", topicFilter: "a/#", cover: false, overlap: true},
+	{subTopic: "a/b/#", topicFilter: "a/+/c", cover: false, overlap: true},
+	{subTopic: "a/b/#", topicFilter: "#", cover: false, overlap: true},
+	{subTopic: "a/b", topicFilter: "+/+", cover: false, overlap: true},
+	{subTopic: "a", topicFilter: "a/#", cover: false, overlap: true},
+	{subTopic: "a/b", topicFilter: "a/c/#", cover: false, overlap: false},
+	{subTopic: "a/b", topicFilter: "+", cover: false, overlap: false},
+	{subTopic: "a/b/c", topicFilter: "a/+", cover: false, overlap: false},
+}
+
+func TestTopicTrie_matchTopicFilter(t *testing.T) {
+	a := assert.New(t)
+	for _, v := range testTopicFilterMatch {
+		trie := newTopicTrie()
+		trie.subscribe("cid", &subscription.Subscription{
+			TopicFilter: v.subTopic,
+		})
+		for _, cover := range []bool{true, false} {
+			rs := make(subscription2.ClientSubscriptions)
+			trie.matchTopicFilter(strings.Split(v.topicFilter, "/"), cover, rs)
+			_, ok := rs["cid"]
+			if cover {
+				a.Equal(v.cover, ok, v.subTopic+" covers "+v.topicFilter)
+			} else {
+				a.Equal(v.overlap, ok, v.subTopic+" overlaps "+v.topicFilter)
+			}
+		}
+	}
+}
+
 func TestTopicTrie_matchedClients_Qos(t *testing.T) {
 	a := assert.New(t)
 	for _, v := range topicMatchQosTest {
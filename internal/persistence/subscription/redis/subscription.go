@@ -116,7 +116,7 @@ func (s *sub) Init(ctx context.Context, clientIDs []string) error {
 
 func (s *sub) Close() error {
 	_ = s.memStore.Close()
-	return nil
+	return s.r.Close()
 }
 
 func (s *sub) Subscribe(ctx context.Context, clientID string, subscriptions ...*subsc.Subscription) (rs subscription.SubscribeResult, err error) {
@@ -2,12 +2,18 @@ package mem
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/unack"
 )
 
 var _ unack.Store = (*Store)(nil)
 
+func init() {
+	persistence.RegisterUnackStore(persistence.Memory, NewStore())
+}
+
 type Store struct {
 	clientID     string
 	unackpublish map[packet.Id]struct{}
@@ -24,6 +30,13 @@ func New(opts Options) *Store {
 	}
 }
 
+// NewStore returns a unack.NewStore which creates memory unack stores.
+func NewStore() unack.NewStore {
+	return func(config *config.StoreType, opts *unack.Options) (unack.Store, error) {
+		return New(Options{ClientID: opts.ClientId}), nil
+	}
+}
+
 func (s *Store) Init(_ context.Context, cleanStart bool) error {
 	if cleanStart {
 		s.unackpublish = make(map[packet.Id]struct{})
@@ -39,7 +52,24 @@ func (s *Store) Set(_ context.Context, id packet.Id) (bool, error) {
 	return false, nil
 }
 
+func (s *Store) Exists(_ context.Context, id packet.Id) (bool, error) {
+	_, ok := s.unackpublish[id]
+	return ok, nil
+}
+
 func (s *Store) Remove(_ context.Context, id packet.Id) error {
 	delete(s.unackpublish, id)
 	return nil
 }
+
+func (s *Store) List(_ context.Context) ([]packet.Id, error) {
+	ids := make([]packet.Id, 0, len(s.unackpublish))
+	for id := range s.unackpublish {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *Store) Close() error {
+	return nil
+}
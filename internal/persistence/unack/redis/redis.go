@@ -2,7 +2,9 @@ package redis
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
 	"github.com/yunqi/lighthouse/internal/persistence/unack"
 	red "github.com/yunqi/lighthouse/internal/redis"
 	"strconv"
@@ -15,6 +17,10 @@ const (
 
 var _ unack.Store = (*Store)(nil)
 
+func init() {
+	persistence.RegisterUnackStore(persistence.Redis, NewStore())
+}
+
 type Store struct {
 	key          string
 	clientID     string
@@ -37,17 +43,43 @@ func New(opts Options) *Store {
 	}
 }
 
+// NewStore returns a unack.NewStore which creates redis unack stores.
+func NewStore() unack.NewStore {
+	return func(config *config.StoreType, opts *unack.Options) (unack.Store, error) {
+		var redisOpts []red.Option
+		switch config.Redis.Type {
+		case red.NodeType:
+			redisOpts = append(redisOpts, red.WithNodeType())
+		case red.ClusterType:
+			redisOpts = append(redisOpts, red.WithClusterType())
+		}
+		return New(Options{
+			ClientID: opts.ClientId,
+			R:        red.New(config.Redis.Addr, redisOpts...),
+		}), nil
+	}
+}
+
 func getKey(clientID string) string {
 	return unackPrefix + clientID
 }
 func (s *Store) Init(ctx context.Context, cleanStart bool) error {
+	s.unackpublish = make(map[packet.Id]struct{})
 	if cleanStart {
-		s.unackpublish = make(map[packet.Id]struct{})
-
 		_, err := s.r.Del(ctx, s.key)
+		return err
+	}
+	// 恢复未确认的 packet id
+	rs, err := s.r.Hgetall(ctx, s.key)
+	if err != nil {
+		return err
+	}
+	for k := range rs {
+		id, err := strconv.ParseUint(k, 10, 16)
 		if err != nil {
 			return err
 		}
+		s.unackpublish[packet.Id(id)] = struct{}{}
 	}
 	return nil
 }
@@ -65,6 +97,12 @@ func (s *Store) Set(ctx context.Context, id packet.Id) (bool, error) {
 	return false, nil
 }
 
+func (s *Store) Exists(_ context.Context, id packet.Id) (bool, error) {
+	// 缓存在 Init 时已从 redis 恢复
+	_, ok := s.unackpublish[id]
+	return ok, nil
+}
+
 func (s *Store) Remove(ctx context.Context, id packet.Id) error {
 	_, err := s.r.Hdel(ctx, s.key, strconv.FormatUint(uint64(id), 10))
 	if err != nil {
@@ -73,3 +111,17 @@ func (s *Store) Remove(ctx context.Context, id packet.Id) error {
 	delete(s.unackpublish, id)
 	return nil
 }
+
+func (s *Store) List(_ context.Context) ([]packet.Id, error) {
+	// 缓存在 Init 时已从 redis 恢复
+	ids := make([]packet.Id, 0, len(s.unackpublish))
+	for id := range s.unackpublish {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Close releases the shared redis client, it is held again when the store is used.
+func (s *Store) Close() error {
+	return s.r.Close()
+}
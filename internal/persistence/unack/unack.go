@@ -2,9 +2,19 @@ package unack
 
 import (
 	"context"
+	"github.com/yunqi/lighthouse/config"
 	"github.com/yunqi/lighthouse/internal/packet"
 )
 
+// NewStore creates the unack store of a client.
+type NewStore func(config *config.StoreType, opts *Options) (Store, error)
+
+// Options is the options of the unack store.
+type Options struct {
+	// ClientId is the client id of the unack store.
+	ClientId string
+}
+
 // Store represents a unack store for one client.
 // Unack store is used to persist the unacknowledged qos2 messages.
 type Store interface {
@@ -15,6 +25,13 @@ type Store interface {
 	// Set sets the given id into store.
 	// The return boolean indicates whether the id exist.
 	Set(ctx context.Context, id packet.Id) (bool, error)
+	// Exists returns whether the given id exists in store.
+	Exists(ctx context.Context, id packet.Id) (bool, error)
 	// Remove removes the given id from store.
 	Remove(ctx context.Context, id packet.Id) error
+	// List returns all ids in store.
+	List(ctx context.Context) ([]packet.Id, error)
+	// Close will be called when the client disconnect.
+	// The store may be used again after Init is called.
+	Close() error
 }
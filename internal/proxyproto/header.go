@@ -0,0 +1,284 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The TLV types of the PROXY protocol v2.
+const (
+	TypeALPN      byte = 0x01
+	TypeAuthority byte = 0x02 // the host name of the TLS SNI
+	TypeCRC32C    byte = 0x03
+	TypeNoop      byte = 0x04
+	TypeUniqueId  byte = 0x05
+	TypeSSL       byte = 0x20
+	TypeNetNS     byte = 0x30
+
+	SubtypeSSLVersion byte = 0x21
+	SubtypeSSLCN      byte = 0x22
+	SubtypeSSLCipher  byte = 0x23
+	SubtypeSSLSigAlg  byte = 0x24
+	SubtypeSSLKeyAlg  byte = 0x25
+)
+
+const (
+	v1MaxLength = 107
+
+	v2CmdLocal = 0x0
+	v2CmdProxy = 0x1
+
+	v2FamilyUnspec = 0x0
+	v2FamilyInet   = 0x1
+	v2FamilyInet6  = 0x2
+	v2FamilyUnix   = 0x3
+
+	v2TransportDgram = 0x2
+)
+
+var (
+	// ErrInvalidHeader is returned when the connection does not start with a valid PROXY header.
+	ErrInvalidHeader = errors.New("proxyproto: invalid header")
+
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+type (
+	// Header is the PROXY header.
+	Header struct {
+		// Version is the version of the PROXY protocol, 1 or 2.
+		Version byte
+		// Local indicates the connection is established by the proxy itself (v2 LOCAL) or the protocol is unknown (v1 UNKNOWN),
+		// Source and Destination are nil.
+		Local bool
+		// Source is the address of the client.
+		Source net.Addr
+		// Destination is the address which the client connected to.
+		Destination net.Addr
+		// TLVs are the type-length-value vectors of v2.
+		TLVs []TLV
+	}
+
+	// TLV is a type-length-value vector of the PROXY protocol v2.
+	TLV struct {
+		Type  byte
+		Value []byte
+	}
+
+	// SSL is the value of the TypeSSL TLV.
+	SSL struct {
+		// Client is the bit field of PP2_CLIENT_SSL, PP2_CLIENT_CERT_CONN and PP2_CLIENT_CERT_SESS.
+		Client byte
+		// Verify is 0 if the client presented a certificate and it was successfully verified.
+		Verify uint32
+		// TLVs are the sub TLVs, e.g. SubtypeSSLVersion and SubtypeSSLCN.
+		TLVs []TLV
+	}
+)
+
+// ReadHeader reads the v1 or v2 PROXY header from r, no more bytes than the header are read.
+func ReadHeader(r io.Reader) (*Header, error) {
+	// 最短的 v1 头部 "PROXY UNKNOWN\r\n" 也有 15 字节
+	b := make([]byte, len(v2Signature))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, v2Signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(b, v1Prefix) {
+		return readV1(r, b)
+	}
+	return nil, ErrInvalidHeader
+}
+
+func readV1(r io.Reader, prefix []byte) (*Header, error) {
+	line := make([]byte, len(prefix), v1MaxLength)
+	copy(line, prefix)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == v1MaxLength {
+			return nil, ErrInvalidHeader
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(protocol, ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (protocol == "TCP4") != (addr.To4() != nil) {
+		return nil, ErrInvalidHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readV2(r io.Reader) (*Header, error) {
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if b[0]>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	command, family, transport := b[0]&0xF, b[1]>>4, b[1]&0xF
+	payload := make([]byte, binary.BigEndian.Uint16(b[2:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch command {
+	case v2CmdLocal:
+		// LOCAL 命令的地址信息必须被忽略
+		h.Local = true
+		return h, nil
+	case v2CmdProxy:
+	default:
+		return nil, ErrInvalidHeader
+	}
+
+	var n int
+	switch family {
+	case v2FamilyUnspec:
+		h.Local = true
+	case v2FamilyInet, v2FamilyInet6:
+		ipLen := net.IPv4len
+		if family == v2FamilyInet6 {
+			ipLen = net.IPv6len
+		}
+		n = 2*ipLen + 4
+		if len(payload) < n {
+			return nil, ErrInvalidHeader
+		}
+		srcIP, dstIP := net.IP(payload[:ipLen]), net.IP(payload[ipLen:2*ipLen])
+		srcPort, dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen:])), int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+		if transport == v2TransportDgram {
+			h.Source, h.Destination = &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		} else {
+			h.Source, h.Destination = &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+		}
+	case v2FamilyUnix:
+		n = 216
+		if len(payload) < n {
+			return nil, ErrInvalidHeader
+		}
+		network := "unix"
+		if transport == v2TransportDgram {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Net: network, Name: string(bytes.TrimRight(payload[:108], "\x00"))}
+		h.Destination = &net.UnixAddr{Net: network, Name: string(bytes.TrimRight(payload[108:216], "\x00"))}
+	default:
+		return nil, ErrInvalidHeader
+	}
+
+	tlvs, err := parseTLVs(payload[n:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	return h, nil
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrInvalidHeader
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, ErrInvalidHeader
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return tlvs, nil
+}
+
+// TLV returns the value of the first TLV of the type.
+func (h *Header) TLV(typ byte) ([]byte, bool) {
+	return findTLV(h.TLVs, typ)
+}
+
+// Authority returns the host name of the TLS SNI sent by the client, empty if it is not present.
+func (h *Header) Authority() string {
+	v, _ := h.TLV(TypeAuthority)
+	return string(v)
+}
+
+// SSL returns the value of the TypeSSL TLV, false if the TLV is not present or invalid.
+func (h *Header) SSL() (*SSL, bool) {
+	v, ok := h.TLV(TypeSSL)
+	if !ok || len(v) < 5 {
+		return nil, false
+	}
+	tlvs, err := parseTLVs(v[5:])
+	if err != nil {
+		return nil, false
+	}
+	return &SSL{Client: v[0], Verify: binary.BigEndian.Uint32(v[1:5]), TLVs: tlvs}, true
+}
+
+// TLV returns the value of the first sub TLV of the type.
+func (s *SSL) TLV(typ byte) ([]byte, bool) {
+	return findTLV(s.TLVs, typ)
+}
+
+func findTLV(tlvs []TLV, typ byte) ([]byte, bool) {
+	for _, tlv := range tlvs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,165 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// v2Header builds a v2 header with the payload.
+func v2Header(verCmd, family byte, payload []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func tlv(typ byte, value []byte) []byte {
+	b := []byte{typ, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], uint16(len(value)))
+	return append(b, value...)
+}
+
+func TestReadHeader_v1(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   *Header
+		err    bool
+	}{
+		{
+			name:   "tcp4",
+			header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 1883\r\n",
+			want: &Header{
+				Version:     1,
+				Source:      &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324},
+				Destination: &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 1883},
+			},
+		},
+		{
+			name:   "tcp6",
+			header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 1883\r\n",
+			want: &Header{
+				Version:     1,
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1883},
+			},
+		},
+		{name: "unknown", header: "PROXY UNKNOWN\r\n", want: &Header{Version: 1, Local: true}},
+		{name: "unknownWithAddr", header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", want: &Header{Version: 1, Local: true}},
+		{name: "familyMismatch", header: "PROXY TCP4 2001:db8::1 2001:db8::2 56324 1883\r\n", err: true},
+		{name: "invalidPort", header: "PROXY TCP4 192.168.0.1 192.168.0.11 65536 1883\r\n", err: true},
+		{name: "leadingZeroPort", header: "PROXY TCP4 192.168.0.1 192.168.0.11 0883 1883\r\n", err: true},
+		{name: "missingField", header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n", err: true},
+		{name: "tooLong", header: "PROXY TCP4 " + string(bytes.Repeat([]byte{'1'}, 100)) + "\r\n", err: true},
+		{name: "notProxy", header: "GET / HTTP/1.1\r\n", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader([]byte(tt.header + "mqtt"))
+			h, err := ReadHeader(r)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, h)
+			// 头部之后的数据不被读取
+			rest := make([]byte, 4)
+			_, _ = r.Read(rest)
+			assert.Equal(t, "mqtt", string(rest))
+		})
+	}
+}
+
+func TestReadHeader_v2(t *testing.T) {
+	inet := []byte{192, 168, 0, 1, 192, 168, 0, 11, 0xDC, 0x04, 0x07, 0x5B}
+	inet6 := append(append(append([]byte{}, net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xDC, 0x04, 0x07, 0x5B)
+	ssl := append([]byte{0x07, 0, 0, 0, 0}, tlv(SubtypeSSLCN, []byte("device-1"))...)
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   *Header
+		err    bool
+	}{
+		{
+			name:   "tcp4",
+			header: v2Header(0x21, 0x11, inet),
+			want: &Header{
+				Version:     2,
+				Source:      &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 56324},
+				Destination: &net.TCPAddr{IP: net.IP{192, 168, 0, 11}, Port: 1883},
+			},
+		},
+		{
+			name:   "tcp6",
+			header: v2Header(0x21, 0x21, inet6),
+			want: &Header{
+				Version:     2,
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1883},
+			},
+		},
+		{
+			name:   "tlv",
+			header: v2Header(0x21, 0x11, append(append(append([]byte{}, inet...), tlv(TypeAuthority, []byte("mqtt.example.com"))...), tlv(TypeSSL, ssl)...)),
+			want: &Header{
+				Version:     2,
+				Source:      &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 56324},
+				Destination: &net.TCPAddr{IP: net.IP{192, 168, 0, 11}, Port: 1883},
+				TLVs:        []TLV{{Type: TypeAuthority, Value: []byte("mqtt.example.com")}, {Type: TypeSSL, Value: ssl}},
+			},
+		},
+		{name: "local", header: v2Header(0x20, 0x00, nil), want: &Header{Version: 2, Local: true}},
+		{name: "localIgnoreAddr", header: v2Header(0x20, 0x11, inet), want: &Header{Version: 2, Local: true}},
+		{name: "unspec", header: v2Header(0x21, 0x00, nil), want: &Header{Version: 2, Local: true}},
+		{name: "invalidVersion", header: v2Header(0x11, 0x11, inet), err: true},
+		{name: "invalidCommand", header: v2Header(0x22, 0x11, inet), err: true},
+		{name: "invalidFamily", header: v2Header(0x21, 0x41, inet), err: true},
+		{name: "shortAddr", header: v2Header(0x21, 0x21, inet), err: true},
+		{name: "truncatedTLV", header: v2Header(0x21, 0x11, append(append([]byte{}, inet...), TypeAuthority, 0, 10, 'a')), err: true},
+		{name: "truncatedPayload", header: v2Header(0x21, 0x11, inet)[:20], err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ReadHeader(bytes.NewReader(tt.header))
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, h)
+		})
+	}
+
+	h, err := ReadHeader(bytes.NewReader(tests[2].header))
+	assert.NoError(t, err)
+	assert.Equal(t, "mqtt.example.com", h.Authority())
+	s, ok := h.SSL()
+	assert.True(t, ok)
+	assert.Equal(t, byte(0x07), s.Client)
+	assert.Equal(t, uint32(0), s.Verify)
+	cn, ok := s.TLV(SubtypeSSLCN)
+	assert.True(t, ok)
+	assert.Equal(t, "device-1", string(cn))
+}
@@ -0,0 +1,148 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package proxyproto implements the receiver of the HAProxy PROXY protocol v1 and v2.
+// See https://www.haproxy.org/download/2.4/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultHeaderTimeout = 5 * time.Second
+
+var (
+	// ErrNoTrustedCIDR is returned if there is no trusted source, the PROXY header can not be trusted from any client.
+	ErrNoTrustedCIDR = errors.New("proxyproto: no trusted CIDR")
+
+	_ net.Listener = (*Listener)(nil)
+	_ net.Conn     = (*Conn)(nil)
+)
+
+type (
+	// Listener wraps a net.Listener, the connections from the trusted sources must start with a PROXY header.
+	// The connections from the other sources are returned as they are.
+	Listener struct {
+		net.Listener
+		trusted       []*net.IPNet
+		headerTimeout time.Duration
+	}
+
+	// Conn is a connection which starts with a PROXY header.
+	// The header is read on the first call of Read, RemoteAddr, LocalAddr or Header.
+	Conn struct {
+		net.Conn
+		headerTimeout time.Duration
+		once          sync.Once
+		header        *Header
+		err           error
+	}
+)
+
+// NewListener returns a Listener, trustedCIDRs must not be empty, otherwise any client can spoof its address.
+// headerTimeout is the timeout to read the PROXY header, default to 5s.
+func NewListener(ln net.Listener, trustedCIDRs []string, headerTimeout time.Duration) (*Listener, error) {
+	if len(trustedCIDRs) == 0 {
+		return nil, ErrNoTrustedCIDR
+	}
+	l := &Listener{Listener: ln, headerTimeout: headerTimeout}
+	if l.headerTimeout <= 0 {
+		l.headerTimeout = defaultHeaderTimeout
+	}
+	for _, cidr := range trustedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("proxyproto: invalid trusted CIDR %q", cidr)
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		l.trusted = append(l.trusted, ipNet)
+	}
+	return l, nil
+}
+
+// Accept returns the next connection, it does not wait for the PROXY header.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, headerTimeout: l.headerTimeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns the PROXY header, the connection is closed if the header is invalid.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+	c.header, c.err = ReadHeader(c.Conn)
+	if c.err != nil {
+		_ = c.Conn.Close()
+		return
+	}
+	_ = c.Conn.SetReadDeadline(time.Time{})
+}
+
+// Read reads the data after the PROXY header.
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the source address of the PROXY header,
+// or the address of the proxy if the header is invalid or does not carry the addresses.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the PROXY header,
+// or the local address if the header is invalid or does not carry the addresses.
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,106 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package proxyproto
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(t *testing.T, trustedCIDRs []string, headerTimeout time.Duration) (*Listener, chan net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	l, err := NewListener(ln, trustedCIDRs, headerTimeout)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+	return l, conns
+}
+
+func TestListener(t *testing.T) {
+	a := assert.New(t)
+	l, conns := serve(t, []string{"127.0.0.0/8"}, 0)
+	client, err := net.Dial("tcp", l.Addr().String())
+	a.NoError(err)
+	defer client.Close()
+	_, err = client.Write([]byte("PROXY TCP4 10.0.0.1 10.0.0.2 56324 1883\r\nmqtt"))
+	a.NoError(err)
+	_ = client.(*net.TCPConn).CloseWrite()
+
+	conn := <-conns
+	a.Equal("10.0.0.1:56324", conn.RemoteAddr().String())
+	a.Equal("10.0.0.2:1883", conn.LocalAddr().String())
+	b, err := ioutil.ReadAll(conn)
+	a.NoError(err)
+	a.Equal("mqtt", string(b))
+}
+
+func TestListener_untrusted(t *testing.T) {
+	a := assert.New(t)
+	l, conns := serve(t, []string{"10.0.0.0/8", "192.168.0.1"}, 0)
+	client, err := net.Dial("tcp", l.Addr().String())
+	a.NoError(err)
+	defer client.Close()
+
+	// 不可信来源的连接按直连处理
+	conn := <-conns
+	_, ok := conn.(*Conn)
+	a.False(ok)
+	a.Equal(client.LocalAddr().String(), conn.RemoteAddr().String())
+}
+
+func TestListener_invalidHeader(t *testing.T) {
+	a := assert.New(t)
+	l, conns := serve(t, []string{"127.0.0.1"}, 50*time.Millisecond)
+	client, err := net.Dial("tcp", l.Addr().String())
+	a.NoError(err)
+	defer client.Close()
+
+	conn := <-conns
+	// 超时未收到头部
+	_, err = conn.Read(make([]byte, 1))
+	a.Error(err)
+	a.Equal(client.LocalAddr().String(), conn.RemoteAddr().String())
+	_, err = conn.(*Conn).Header()
+	a.Error(err)
+}
+
+func TestNewListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	_, err = NewListener(ln, []string{"10.0.0.0/33"}, 0)
+	assert.Error(t, err)
+	_, err = NewListener(ln, nil, 0)
+	assert.ErrorIs(t, err, ErrNoTrustedCIDR)
+	l, err := NewListener(ln, []string{"::1"}, 0)
+	assert.NoError(t, err)
+	assert.True(t, l.isTrusted(&net.TCPAddr{IP: net.ParseIP("::1")}))
+	assert.False(t, l.isTrusted(&net.TCPAddr{IP: net.ParseIP("::2")}))
+}
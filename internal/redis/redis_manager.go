@@ -3,9 +3,11 @@ package redis
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"github.com/chenquan/go-pkg/xsync"
 	"github.com/go-redis/redis/v8"
 	"io"
+	"sync"
 )
 
 const (
@@ -17,8 +19,67 @@ const (
 var (
 	clusterManager = xsync.NewResourceManager()
 	clientManager  = xsync.NewResourceManager()
+
+	refMu sync.Mutex
+	// refs is the number of Redis which hold the shared client, keyed by refKey.
+	refs = map[string]int{}
+
+	errNotCreated = errors.New("redis: client is not created")
 )
 
+func refKey(r *Redis) string {
+	return string(r.option.Type) + "|" + r.addr
+}
+
+func manager(r *Redis) *xsync.ResourceManager {
+	if r.option.Type == ClusterType {
+		return clusterManager
+	}
+	return clientManager
+}
+
+// acquire holds a reference of the shared client until the Redis is closed.
+func (r *Redis) acquire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.held {
+		return
+	}
+	refMu.Lock()
+	refs[refKey(r)]++
+	refMu.Unlock()
+	r.held = true
+}
+
+// release releases the reference held by the Redis,
+// the shared client is removed from the manager and closed when there is no reference.
+func (r *Redis) release() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.held {
+		return nil
+	}
+	r.held = false
+
+	refMu.Lock()
+	defer refMu.Unlock()
+	key := refKey(r)
+	if refs[key]--; refs[key] > 0 {
+		return nil
+	}
+	delete(refs, key)
+	m := manager(r)
+	val, err := m.Get(r.addr, func() (io.Closer, error) {
+		return nil, errNotCreated
+	})
+	if err != nil {
+		// 客户端未创建成功，无需关闭
+		return nil
+	}
+	m.Remove(r.addr)
+	return val.Close()
+}
+
 func getCluster(r *Redis) (*redis.ClusterClient, error) {
 
 	val, err := clusterManager.Get(r.addr, func() (io.Closer, error) {
@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRedis_release(t *testing.T) {
+	a := assert.New(t)
+	r1 := New("127.0.0.1:0")
+	r2 := New("127.0.0.1:0")
+	key := refKey(r1)
+
+	r1.acquire()
+	r1.acquire()
+	r2.acquire()
+	a.Equal(2, refs[key])
+
+	a.NoError(r1.Close())
+	a.NoError(r1.Close())
+	a.Equal(1, refs[key])
+
+	// 最后一个引用释放，未创建的客户端无需关闭
+	a.NoError(r2.Close())
+	_, ok := refs[key]
+	a.False(ok)
+}
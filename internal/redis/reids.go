@@ -6,6 +6,7 @@ import (
 	red "github.com/go-redis/redis/v8"
 	"github.com/yunqi/lighthouse/internal/breaker"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -20,6 +21,9 @@ type (
 		addr   string
 		option *option
 		brk    breaker.Breaker
+		mu     sync.Mutex
+		// held reports whether the Redis holds a reference of the shared client, see acquire.
+		held bool
 	}
 	option struct {
 		Type         Type
@@ -76,6 +80,7 @@ func New(addr string, opts ...Option) *Redis {
 }
 
 func (r *Redis) getRedis() (Client, error) {
+	r.acquire()
 	switch r.option.Type {
 	case ClusterType:
 		return getCluster(r)
@@ -343,10 +348,9 @@ func (r *Redis) getContext(ctx context.Context) (context.Context, context.Cancel
 	return context.WithTimeout(ctx, r.option.Timeout)
 }
 
+// Close releases the shared client of the address,
+// the client is closed after it is released by all Redis of the address.
+// The Redis holds the client again if it is used after Close.
 func (r *Redis) Close() error {
-	conn, err := r.getRedis()
-	if err != nil {
-		return err
-	}
-	return conn.Close()
+	return r.release()
 }
@@ -0,0 +1,348 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"testing"
+	"time"
+)
+
+func TestServer_puback(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.MaxInflight = 2
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	sub.subscribe(1, &packet.Topic{Name: "a", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	for i := 1; i <= 5; i++ {
+		pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: packet.Id(i), TopicName: []byte("a"), Payload: []byte{byte(i)}})
+		_, ok := pub.read().(*packet.Puback)
+		a.True(ok)
+	}
+
+	var ids []packet.Id
+	for i := 1; i <= 2; i++ {
+		publish, ok := sub.read().(*packet.Publish)
+		a.True(ok)
+		a.Equal([]byte{byte(i)}, publish.Payload)
+		ids = append(ids, publish.PacketId)
+	}
+	// 超过 MaxInflight 的消息等待确认后再发送
+	sub.write(&packet.Pingreq{})
+	_, ok := sub.read().(*packet.Pingresp)
+	a.True(ok)
+
+	for i := 3; i <= 5; i++ {
+		sub.write(&packet.Puback{PacketId: ids[0]})
+		ids = ids[1:]
+		publish, ok := sub.read().(*packet.Publish)
+		a.True(ok)
+		a.Equal([]byte{byte(i)}, publish.Payload)
+		ids = append(ids, publish.PacketId)
+	}
+}
+
+func TestServer_pubrecPubcomp(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", false).Code)
+	sub.subscribe(1, &packet.Topic{Name: "a", SubOptions: packet.SubOptions{QoS: packet.QoS2}})
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	pub.write(&packet.Publish{QoS: packet.QoS2, PacketId: 1, TopicName: []byte("a"), Payload: []byte("exactly once")})
+	_, ok := pub.read().(*packet.Pubrec)
+	a.True(ok)
+
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal(packet.QoS2, publish.QoS)
+	sub.write(&packet.Pubrec{PacketId: publish.PacketId})
+	pubrel, ok := sub.read().(*packet.Pubrel)
+	a.True(ok)
+	a.Equal(publish.PacketId, pubrel.PacketId)
+
+	// 会话恢复后重发 PUBREL 而不是 PUBLISH
+	_ = sub.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	sub = dial(t, s)
+	a.True(sub.connect("sub", false).SessionPresent)
+	pubrel, ok = sub.read().(*packet.Pubrel)
+	a.True(ok)
+	a.Equal(publish.PacketId, pubrel.PacketId)
+	sub.write(&packet.Pubcomp{PacketId: pubrel.PacketId})
+
+	// 确认完成后不再重发
+	_ = sub.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	sub = dial(t, s)
+	a.True(sub.connect("sub", false).SessionPresent)
+	sub.write(&packet.Pingreq{})
+	_, ok = sub.read().(*packet.Pingresp)
+	a.True(ok)
+}
+
+func TestServer_pubrecV5(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connectV5("sub", true, nil).Code)
+	sub.subscribe(1, &packet.Topic{Name: "a", SubOptions: packet.SubOptions{QoS: packet.QoS2}})
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connectV5("pub", true, nil).Code)
+	pub.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS2, PacketId: 1, TopicName: []byte("a"), Payload: []byte("rejected")})
+	_, ok := pub.read().(*packet.Pubrec)
+	a.True(ok)
+
+	// 失败的 Reason Code 结束 QoS 2 流程
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	sub.write(&packet.Pubrec{Version: packet.Version5, PacketId: publish.PacketId, Code: code.UnspecifiedError})
+	sub.write(&packet.Pingreq{})
+	_, ok = sub.read().(*packet.Pingresp)
+	a.True(ok)
+
+	// 未知的 Packet Identifier
+	sub.write(&packet.Pubrec{Version: packet.Version5, PacketId: 100})
+	pubrel, ok := sub.read().(*packet.Pubrel)
+	a.True(ok)
+	a.Equal(packet.Id(100), pubrel.PacketId)
+	a.Equal(code.PacketIDNotFound, pubrel.Code)
+}
+
+func TestServer_qos2ExactlyOnce(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	sub.subscribe(1, &packet.Topic{Name: "billing"})
+	// expectMessages 读取消息并确认没有多余的消息
+	expectMessages := func(payloads ...string) {
+		for _, payload := range payloads {
+			publish, ok := sub.read().(*packet.Publish)
+			a.True(ok)
+			a.Equal(payload, string(publish.Payload))
+		}
+		sub.write(&packet.Pingreq{})
+		_, ok := sub.read().(*packet.Pingresp)
+		a.True(ok)
+	}
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", false).Code)
+	publish := &packet.Publish{QoS: packet.QoS2, PacketId: 1, TopicName: []byte("billing"), Payload: []byte("event")}
+	pub.write(publish)
+	pubrec, ok := pub.read().(*packet.Pubrec)
+	a.True(ok)
+	a.Equal(packet.Id(1), pubrec.PacketId)
+	// 重传的 PUBLISH 只响应 PUBREC
+	publish.Dup = true
+	pub.write(publish)
+	_, ok = pub.read().(*packet.Pubrec)
+	a.True(ok)
+	expectMessages("event")
+
+	// 会话恢复后依然去重
+	_ = pub.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	pub = dial(t, s)
+	a.True(pub.connect("pub", false).SessionPresent)
+	pub.write(publish)
+	_, ok = pub.read().(*packet.Pubrec)
+	a.True(ok)
+	pub.write(&packet.Pubrel{PacketId: 1})
+	pubcomp, ok := pub.read().(*packet.Pubcomp)
+	a.True(ok)
+	a.Equal(packet.Id(1), pubcomp.PacketId)
+	expectMessages()
+
+	// PUBREL 之后 Packet Identifier 可以用于新的消息
+	pub.write(&packet.Publish{QoS: packet.QoS2, PacketId: 1, TopicName: []byte("billing"), Payload: []byte("next")})
+	_, ok = pub.read().(*packet.Pubrec)
+	a.True(ok)
+	expectMessages("next")
+}
+
+func TestServer_pubrelV5(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("pub", true, nil).Code)
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS2, PacketId: 1, TopicName: []byte("a")})
+	_, ok := c.read().(*packet.Pubrec)
+	a.True(ok)
+	c.write(&packet.Pubrel{Version: packet.Version5, PacketId: 1})
+	pubcomp, ok := c.read().(*packet.Pubcomp)
+	a.True(ok)
+	a.Equal(code.Success, pubcomp.Code)
+
+	// 未知的 Packet Identifier
+	c.write(&packet.Pubrel{Version: packet.Version5, PacketId: 1})
+	pubcomp, ok = c.read().(*packet.Pubcomp)
+	a.True(ok)
+	a.Equal(code.PacketIDNotFound, pubcomp.Code)
+}
+
+func TestServer_receiveMaximum(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.ReceiveMax = 2
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	c := dial(t, s)
+	connack := c.connectV5("flood", true, nil)
+	a.Equal(code.Success, connack.Code)
+	a.Equal(uint16(2), *connack.Properties.ReceiveMaximum)
+	publish := func(id packet.Id, qos uint8) {
+		c.write(&packet.Publish{Version: packet.Version5, QoS: qos, PacketId: id, TopicName: []byte("a")})
+	}
+	publish(1, packet.QoS2)
+	publish(2, packet.QoS2)
+	// 重传不占用额外的配额
+	publish(1, packet.QoS2)
+	for i := 0; i < 3; i++ {
+		_, ok := c.read().(*packet.Pubrec)
+		a.True(ok)
+	}
+	c.write(&packet.Pubrel{Version: packet.Version5, PacketId: 1})
+	_, ok := c.read().(*packet.Pubcomp)
+	a.True(ok)
+	publish(3, packet.QoS2)
+	_, ok = c.read().(*packet.Pubrec)
+	a.True(ok)
+
+	publish(4, packet.QoS1)
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.RecvMaxExceeded, disconnect.Code)
+}
+
+func TestServer_receiveMaximumQoS1(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.ReceiveMax = 1
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("flood", true, nil).Code)
+	// 已确认的 QoS 1 消息不占用配额
+	for i := 1; i <= 3; i++ {
+		c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS1, PacketId: packet.Id(i), TopicName: []byte("a")})
+		_, ok := c.read().(*packet.Puback)
+		a.True(ok)
+	}
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS2, PacketId: 4, TopicName: []byte("a")})
+	_, ok := c.read().(*packet.Pubrec)
+	a.True(ok)
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS1, PacketId: 5, TopicName: []byte("a")})
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.RecvMaxExceeded, disconnect.Code)
+}
+
+func TestServer_receiveMaximumResumed(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.ReceiveMax = 1
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	expiry := uint32(60)
+	props := &packet.Properties{SessionExpiryInterval: &expiry}
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("resumed", false, props).Code)
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS2, PacketId: 1, TopicName: []byte("a")})
+	_, ok := c.read().(*packet.Pubrec)
+	a.True(ok)
+	_ = c.conn.Close()
+
+	// 恢复会话后，未释放的 QoS 2 消息仍占用配额
+	c = dial(t, s)
+	a.True(c.connectV5("resumed", false, props).SessionPresent)
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS2, PacketId: 2, TopicName: []byte("a")})
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.RecvMaxExceeded, disconnect.Code)
+}
+
+func TestServer_clientReceiveMaximum(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	receiveMax := uint16(1)
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connectV5("sub", true, &packet.Properties{ReceiveMaximum: &receiveMax}).Code)
+	sub.subscribe(1, &packet.Topic{Name: "a", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	for i := 1; i <= 3; i++ {
+		pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: packet.Id(i), TopicName: []byte("a"), Payload: []byte{byte(i)}})
+		_, ok := pub.read().(*packet.Puback)
+		a.True(ok)
+	}
+
+	s.mu.RLock()
+	c := s.clients["sub"]
+	s.mu.RUnlock()
+	a.Equal(uint16(1), c.ClientOption().MaxInflight)
+
+	// 每次只发送一条消息，确认后再发送下一条
+	for i := 1; i <= 3; i++ {
+		publish, ok := sub.read().(*packet.Publish)
+		a.True(ok)
+		a.Equal([]byte{byte(i)}, publish.Payload)
+		sub.write(&packet.Pingreq{})
+		_, ok = sub.read().(*packet.Pingresp)
+		a.True(ok)
+		a.Equal(ClientStats{InflightMessages: 1, QueuedMessages: int64(3 - i)}, c.Stats())
+		sub.write(&packet.Puback{Version: packet.Version5, PacketId: publish.PacketId})
+	}
+	sub.write(&packet.Pingreq{})
+	_, ok := sub.read().(*packet.Pingresp)
+	a.True(ok)
+	a.Equal(ClientStats{}, c.Stats())
+}
+
+func Test_maxInflight(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.MaxInflight = 10
+	small, large := uint16(5), uint16(20)
+	a.Equal(uint16(10), maxInflight(&packet.Connect{Version: packet.Version311}, &mqtt))
+	a.Equal(uint16(10), maxInflight(&packet.Connect{Version: packet.Version5}, &mqtt))
+	a.Equal(uint16(5), maxInflight(&packet.Connect{Version: packet.Version5, Properties: &packet.Properties{ReceiveMaximum: &small}}, &mqtt))
+	a.Equal(uint16(10), maxInflight(&packet.Connect{Version: packet.Version5, Properties: &packet.Properties{ReceiveMaximum: &large}}, &mqtt))
+
+	mqtt.MaxInflight = 0
+	a.Equal(packet.MaxPacketID, maxInflight(&packet.Connect{Version: packet.Version311}, &mqtt))
+	a.Equal(uint16(20), maxInflight(&packet.Connect{Version: packet.Version5, Properties: &packet.Properties{ReceiveMaximum: &large}}, &mqtt))
+}
@@ -0,0 +1,154 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrAuthMethodNotFound 不支持的认证方法
+	ErrAuthMethodNotFound = xerror.NewError(code.BadAuthMethod)
+)
+
+type (
+	// EnhancedAuthenticator is the extension point of the MQTT v5 enhanced authentication, such as SCRAM-SHA-256.
+	// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901256
+	EnhancedAuthenticator interface {
+		// Method returns the Authentication Method which the authenticator supports, e.g. "SCRAM-SHA-256".
+		Method() string
+		// Begin starts a new authentication exchange for the client.
+		// It is called when the CONNECT packet contains the Authentication Method,
+		// or when the client starts a re-authentication by sending the AUTH packet with Reason Code 0x19.
+		Begin(ctx context.Context, clientId, username string) AuthExchange
+	}
+	// AuthExchange holds the state of an authentication exchange.
+	AuthExchange interface {
+		// Step handles the Authentication Data sent by the client.
+		// If done is false, the returned data will be sent to the client in an AUTH packet with Reason Code 0x18 (Continue authentication).
+		// If done is true, the authentication succeeded and the returned data will be sent to the client in the CONNACK packet,
+		// or in the AUTH packet with Reason Code 0x00 (Success) for re-authentication.
+		// The authentication fails if err is not nil. If err is a *xerror.Error, its Code will be used as the Reason Code,
+		// otherwise 0x87 (Not authorized) is used.
+		Step(ctx context.Context, authData []byte) (data []byte, done bool, err error)
+	}
+)
+
+// WithEnhancedAuth adds the enhanced authenticators, the Authentication Method of them must be different.
+func WithEnhancedAuth(authenticators ...EnhancedAuthenticator) Option {
+	return func(opts *Options) {
+		opts.enhancedAuths = append(opts.enhancedAuths, authenticators...)
+	}
+}
+
+// authErrorCode returns the Reason Code of the authentication error.
+func authErrorCode(err error) code.Code {
+	var e *xerror.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return code.NotAuthorized
+}
+
+// enhancedAuth runs the enhanced authentication exchange of the CONNECT packet.
+// It returns the Authentication Data which should be sent in the CONNACK packet.
+func (c *client) enhancedAuth(ctx context.Context, connect *packet.Connect) (authData []byte, err error) {
+	method := string(connect.Properties.AuthMethod)
+	authenticator, ok := c.server.enhancedAuths[method]
+	if !ok {
+		return nil, ErrAuthMethodNotFound
+	}
+	exchange := authenticator.Begin(ctx, string(connect.ClientId), string(connect.Username))
+	data := connect.Properties.AuthData
+	for {
+		authData, done, err := exchange.Step(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return authData, nil
+		}
+		c.write(ctx, &packet.Auth{
+			Version: connect.Version,
+			Code:    code.ContinueAuthentication,
+			Properties: &packet.Properties{
+				AuthMethod: connect.Properties.AuthMethod,
+				AuthData:   authData,
+			},
+		})
+		p, err := c.packetReader.Read()
+		if err != nil {
+			return nil, err
+		}
+		auth, ok := p.(*packet.Auth)
+		if !ok || auth.Code != code.ContinueAuthentication || auth.Properties == nil ||
+			string(auth.Properties.AuthMethod) != method {
+			return nil, xerror.ErrProtocol
+		}
+		data = auth.Properties.AuthData
+	}
+}
+
+// handleAuth handles the AUTH packet received on an established connection, which is used for re-authentication.
+func (c *client) handleAuth(auth *packet.Auth) *xerror.Error {
+	ctx, span, logger := c.getTraceLog("auth")
+	defer span.End()
+	logger.Debug("received auth packet", zap.String("packet", auth.String()))
+
+	// 只有在 CONNECT 中使用了增强认证的客户端才能重新认证，且认证方法不能改变
+	if c.authMethod == "" || auth.Properties == nil || string(auth.Properties.AuthMethod) != c.authMethod {
+		return xerror.ErrProtocol
+	}
+	switch auth.Code {
+	case code.ReAuthenticate:
+		if c.authExchange != nil {
+			return xerror.ErrProtocol
+		}
+		c.authExchange = c.server.enhancedAuths[c.authMethod].Begin(ctx, c.clientId, c.opt.Username)
+	case code.ContinueAuthentication:
+		if c.authExchange == nil {
+			return xerror.ErrProtocol
+		}
+	default:
+		return xerror.ErrProtocol
+	}
+	data, done, err := c.authExchange.Step(ctx, auth.Properties.AuthData)
+	if err != nil {
+		logger.Debug("re-authentication failed", zap.Error(err))
+		c.authExchange = nil
+		return xerror.NewError(authErrorCode(err))
+	}
+	cd := code.ContinueAuthentication
+	if done {
+		c.authExchange = nil
+		cd = code.Success
+	}
+	c.write(ctx, &packet.Auth{
+		Version: c.version,
+		Code:    cd,
+		Properties: &packet.Properties{
+			AuthMethod: auth.Properties.AuthMethod,
+			AuthData:   data,
+		},
+	})
+	return nil
+}
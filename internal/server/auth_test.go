@@ -0,0 +1,186 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"net"
+	"testing"
+)
+
+// challengeAuth is a two-step authenticator: the client must answer the "challenge" with "response".
+type challengeAuth struct{}
+
+type challengeExchange struct {
+	challenged bool
+}
+
+func (challengeAuth) Method() string {
+	return "challenge"
+}
+
+func (challengeAuth) Begin(_ context.Context, _, _ string) AuthExchange {
+	return &challengeExchange{}
+}
+
+func (e *challengeExchange) Step(_ context.Context, authData []byte) ([]byte, bool, error) {
+	if !e.challenged {
+		e.challenged = true
+		return []byte("challenge"), false, nil
+	}
+	if !bytes.Equal(authData, []byte("response")) {
+		return nil, false, errors.New("bad response")
+	}
+	return []byte("ok"), true, nil
+}
+
+func (c *testClient) authConnect(clientId string, method string) {
+	c.isV5 = true
+	c.r.SetVersion(packet.Version5)
+	c.write(&packet.Connect{
+		Version:       packet.Version5,
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(packet.Version5),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: true},
+		ClientId:      []byte(clientId),
+		Properties:    &packet.Properties{AuthMethod: []byte(method)},
+	})
+}
+
+func (c *testClient) auth(cd code.Code, data string) {
+	c.write(&packet.Auth{
+		Version:    packet.Version5,
+		Code:       cd,
+		Properties: &packet.Properties{AuthMethod: []byte("challenge"), AuthData: []byte(data)},
+	})
+}
+
+func TestServer_enhancedAuth(t *testing.T) {
+	s := newTestServer(t, WithEnhancedAuth(challengeAuth{}))
+
+	t.Run("success", func(t *testing.T) {
+		a := assert.New(t)
+		c := dial(t, s)
+		c.authConnect("auth", "challenge")
+		auth, ok := c.read().(*packet.Auth)
+		a.True(ok)
+		a.Equal(code.ContinueAuthentication, auth.Code)
+		a.Equal([]byte("challenge"), auth.Properties.AuthData)
+
+		c.auth(code.ContinueAuthentication, "response")
+		connack, ok := c.read().(*packet.Connack)
+		a.True(ok)
+		a.Equal(code.Success, connack.Code)
+		a.Equal([]byte("challenge"), connack.Properties.AuthMethod)
+		a.Equal([]byte("ok"), connack.Properties.AuthData)
+
+		// re-authentication
+		c.auth(code.ReAuthenticate, "")
+		auth, ok = c.read().(*packet.Auth)
+		a.True(ok)
+		a.Equal(code.ContinueAuthentication, auth.Code)
+		c.auth(code.ContinueAuthentication, "response")
+		auth, ok = c.read().(*packet.Auth)
+		a.True(ok)
+		a.Equal(code.Success, auth.Code)
+
+		// failed re-authentication
+		c.auth(code.ReAuthenticate, "")
+		_ = c.read()
+		c.auth(code.ContinueAuthentication, "wrong")
+		disconnect, ok := c.read().(*packet.Disconnect)
+		a.True(ok)
+		a.Equal(code.NotAuthorized, disconnect.Code)
+	})
+
+	t.Run("bad response", func(t *testing.T) {
+		a := assert.New(t)
+		c := dial(t, s)
+		c.authConnect("auth-bad", "challenge")
+		_, ok := c.read().(*packet.Auth)
+		a.True(ok)
+		c.auth(code.ContinueAuthentication, "wrong")
+		connack, ok := c.read().(*packet.Connack)
+		a.True(ok)
+		a.Equal(code.NotAuthorized, connack.Code)
+	})
+
+	t.Run("bad auth method", func(t *testing.T) {
+		a := assert.New(t)
+		c := dial(t, s)
+		c.authConnect("auth-method", "unknown")
+		connack, ok := c.read().(*packet.Connack)
+		a.True(ok)
+		a.Equal(code.BadAuthMethod, connack.Code)
+	})
+}
+
+func TestServer_authenticator(t *testing.T) {
+	s := newTestServer(t, WithAuthenticator(auth.Chain{
+		authenticatorFunc(func(connect *packet.Connect) error {
+			if string(connect.Username) == "foo" {
+				return nil
+			}
+			return auth.ErrSkip
+		}),
+	}))
+	newConnect := func(version packet.Version, username string) *packet.Connect {
+		return &packet.Connect{
+			Version:       version,
+			FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+			ProtocolName:  []byte("MQTT"),
+			ProtocolLevel: byte(version),
+			ConnectFlags:  packet.ConnectFlags{CleanSession: true, UsernameFlag: true},
+			ClientId:      []byte(username),
+			Username:      []byte(username),
+		}
+	}
+	tests := []struct {
+		name     string
+		version  packet.Version
+		username string
+		code     code.Code
+	}{
+		{name: "v3 allowed", version: packet.Version311, username: "foo", code: code.V3Accepted},
+		{name: "v3 rejected", version: packet.Version311, username: "bar", code: code.V3BadUsernameorPassword},
+		{name: "v5 allowed", version: packet.Version5, username: "foo", code: code.Success},
+		{name: "v5 rejected", version: packet.Version5, username: "bar", code: code.BadUserNameOrPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, s)
+			c.r.SetVersion(tt.version)
+			c.write(newConnect(tt.version, tt.username))
+			connack, ok := c.read().(*packet.Connack)
+			assert.True(t, ok)
+			assert.Equal(t, tt.code, connack.Code)
+		})
+	}
+}
+
+type authenticatorFunc func(connect *packet.Connect) error
+
+func (f authenticatorFunc) Authenticate(_ context.Context, connect *packet.Connect, _ net.Addr) error {
+	return f(connect)
+}
@@ -18,24 +18,34 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/chenquan/go-pkg/xio"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/acl"
+	"github.com/yunqi/lighthouse/internal/auth"
 	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/goroutine"
 	"github.com/yunqi/lighthouse/internal/packet"
 	"github.com/yunqi/lighthouse/internal/persistence/message"
 	"github.com/yunqi/lighthouse/internal/persistence/queue"
 	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
+	"github.com/yunqi/lighthouse/internal/proxyproto"
 	"github.com/yunqi/lighthouse/internal/session"
 	sub "github.com/yunqi/lighthouse/internal/subscription"
 	"github.com/yunqi/lighthouse/internal/xerror"
 	"github.com/yunqi/lighthouse/internal/xlog"
+	"github.com/yunqi/lighthouse/internal/xtls"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"io"
 	"net"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -46,6 +56,16 @@ const (
 	Connected
 )
 
+// kickTimeout is the maximum time to wait for the old connection to send the DISCONNECT packet when it is taken over.
+const kickTimeout = 3 * time.Second
+
+// closeConn is written to the out channel to close the connection after the pending packets are written.
+type closeConn struct{}
+
+func (closeConn) Encode(io.Writer) error { return nil }
+func (closeConn) Decode(io.Reader) error { return nil }
+func (closeConn) String() string         { return "close" }
+
 type (
 	Status byte
 	// Client represent a mqtt client.
@@ -66,9 +86,19 @@ type (
 		Close() error
 		// Disconnect sends a disconnect packet to client, it is use to close v5 client.
 		Disconnect(disconnect *packet.Disconnect)
+		// Stats returns the statistics of the outbound messages of the client.
+		Stats() ClientStats
 		Deliverer
 	}
 
+	// ClientStats is the statistics of the outbound messages of the client.
+	ClientStats struct {
+		// InflightMessages is the number of QoS 1 and QoS 2 messages which have been sent but not been acknowledged yet.
+		InflightMessages int64
+		// QueuedMessages is the number of messages waiting in the queue to be sent.
+		QueuedMessages int64
+	}
+
 	// ClientOption is the options which controls how the server interacts with the client.
 	// It will be set after the client has connected.
 	ClientOption struct {
@@ -107,6 +137,9 @@ type (
 		// RequestProblemInfo is the value to indicate whether the Reason String or User Properties should be sent in the case of failures.
 		// See: https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901053
 		RequestProblemInfo bool
+		// ServerName is the TLS SNI sent by the client, it is from the PROXY header if the TLS is terminated by the proxy.
+		// Empty if it is not present.
+		ServerName string
 	}
 	client struct {
 		clientId          string
@@ -120,32 +153,73 @@ type (
 		server            *server
 		in                chan packet.Packet
 		out               chan packet.Packet
+		outMu             sync.RWMutex // guards the close of out
+		outClosed         bool
 		session           *session.Session
 		cleanWillFlag     bool // whether to remove will Msg
 		version           packet.Version
 		opt               *ClientOption //set up before OnConnect()
-		disconnect        *packet.Disconnect
 		closed            chan struct{}
-		connected         chan struct{}
+		done              chan struct{} // closed after the connection and the session cleanup are done
+		takenOver         int32         // whether the connection is taken over by a new connection
 		wg                sync.WaitGroup
+		pollWg            sync.WaitGroup
 		queueStore        queue.Queue
+		unackStore        unack.Store
 		subscriptionStore subscription.Store
 		limit             *packetIdLimiter
+		authMethod        string                 // the Authentication Method in CONNECT
+		clientIdAssigned  bool                   // whether the client id is assigned by the server
+		receiving         map[packet.Id]struct{} // the packet ids of the inbound QoS 2 publications which are not released
+		authExchange      AuthExchange           // the ongoing re-authentication exchange
 		log               *xlog.Log
 		remoteAddr        net.Addr
+		listener          *listener   // the listener which accepts the connection
+		aclInfo           *acl.Client // the client info for the ACL, see aclClient
+		errOnce           sync.Once
+		err               error // the error which causes the connection to be closed
 	}
 )
 
 func (c *client) ClientOption() *ClientOption {
-	panic("implement me")
+	return c.opt
 }
 
-func (c *client) Deliver(message message.Message) error {
-	panic("implement me")
-}
+// Deliver delivers the message to the client according to the client subscriptions which match the message topic.
+// The message will be dropped if it exceeds the maximum packet size of the client.
+func (c *client) Deliver(msg message.Message) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	ctx, span, logger := c.getTraceLog("deliver")
+	defer span.End()
 
-func (c *client) ClientOptions() *ClientOption {
-	return c.opt
+	var subs []*sub.Subscription
+	c.subscriptionStore.Iterate(ctx, func(clientID string, sub *sub.Subscription) bool {
+		subs = append(subs, sub)
+		return true
+	}, subscription.IterationOptions{
+		Type:      subscription.TypeAll,
+		ClientID:  c.clientId,
+		TopicName: msg.Topic,
+		MatchType: subscription.MatchFilter,
+	})
+
+	var msgs []*message.Message
+	var err error
+	for _, m := range c.server.newDeliverMessages("", c.clientId, &msg, subs) {
+		if m.TotalBytes(c.version) > c.opt.ClientMaxPacketSize {
+			logger.Warn("message dropped", zap.String("topic", m.Topic), zap.Error(queue.ErrDropExceedsMaxPacketSize))
+			err = queue.ErrDropExceedsMaxPacketSize
+			c.server.hooks.onMsgDropped(ctx, c.clientId, m, err)
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	if e := c.server.enqueue(ctx, c.clientId, c.queueStore, msgs...); e != nil {
+		return e
+	}
+	return err
 }
 
 func (c *client) Session() *session.Session {
@@ -184,6 +258,18 @@ func (c *client) IsConnecting() bool {
 	return c.status == Connecting
 }
 func (c *client) Disconnect(disconnect *packet.Disconnect) {
+	if !packet.IsVersion5(c.version) {
+		_ = c.Close()
+		return
+	}
+	c.writeDisconnect(context.Background(), disconnect)
+}
+
+// writeDisconnect writes the DISCONNECT packet to the v5 client,
+// the connection will be closed after the DISCONNECT packet is written, see writeConn.
+func (c *client) writeDisconnect(ctx context.Context, disconnect *packet.Disconnect) {
+	disconnect.Version = c.version
+	c.write(ctx, disconnect)
 }
 
 func newClient(server *server, conn net.Conn) *client {
@@ -200,7 +286,7 @@ func newClient(server *server, conn net.Conn) *client {
 		in:                make(chan packet.Packet, 8),
 		out:               make(chan packet.Packet, 8),
 		closed:            make(chan struct{}),
-		connected:         make(chan struct{}),
+		done:              make(chan struct{}),
 		log:               xlog.LoggerModule("client"),
 		remoteAddr:        conn.RemoteAddr(),
 		subscriptionStore: server.subscriptionStore,
@@ -212,6 +298,10 @@ func (c *client) listen() {
 	ctx, span := c.server.tracer.Start(context.Background(), "listen")
 	logger := c.log.WithContext(ctx)
 	logger.Debug("create a new client connection", zap.Any("IP", c.remoteAddr.String()))
+	defer func() {
+		c.server.unregisterClient(c)
+		close(c.done)
+	}()
 
 	c.wg.Add(1)
 	goroutine.Go(func() {
@@ -222,6 +312,10 @@ func (c *client) listen() {
 	// 认证
 	if !c.auth(ctx) {
 		span.End()
+		// 刷新已写入的数据（如 CONNACK）后关闭连接
+		close(c.closed)
+		c.closeOut()
+		c.wg.Wait()
 		return
 	}
 	span.End()
@@ -235,10 +329,10 @@ func (c *client) listen() {
 	})
 
 	// 拉取消息
-	c.wg.Add(1)
+	c.pollWg.Add(1)
 	goroutine.Go(func() {
+		defer c.pollWg.Done()
 		c.pollMessageHandler()
-		c.wg.Done()
 	})
 
 	c.wg.Add(1)
@@ -251,6 +345,102 @@ func (c *client) listen() {
 
 	c.wg.Wait()
 
+	ctx = context.Background()
+	if will := c.session.Will; will != nil && !c.cleanWillFlag {
+		// 遗嘱消息最迟在会话结束时发布
+		delay := c.session.WillDelayInterval
+		if delay > c.opt.SessionExpiry {
+			delay = c.opt.SessionExpiry
+		}
+		if c.checkPublish(will.Topic) {
+			c.server.sendWill(c.clientId, will, delay)
+		}
+	}
+	if c.opt.SessionExpiry == 0 {
+		reason := NormalTermination
+		if atomic.LoadInt32(&c.takenOver) == 1 {
+			reason = TakenOver
+		}
+		c.server.terminateSession(ctx, c.clientId, reason)
+	} else {
+		// 记录断开时间，会话过期时间从断开连接时开始计算
+		s := *c.session
+		s.DisconnectedAt = time.Now()
+		if err := c.server.sessionStore.Set(ctx, &s); err != nil {
+			c.log.Error("set session", zap.String("clientId", c.clientId), zap.Error(err))
+		}
+	}
+	c.server.hooks.onClosed(ctx, c, c.err)
+}
+
+// kick closes the connection because the session is taken over by a new connection,
+// and waits until the connection and the session cleanup are done.
+func (c *client) kick() {
+	atomic.StoreInt32(&c.takenOver, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), kickTimeout)
+	defer cancel()
+	// v5 客户端发送 0x8E，v3 客户端直接关闭连接，
+	// 旧连接可能已不再读取数据，写入须受 kickTimeout 限制
+	if packet.IsVersion5(c.version) {
+		c.writeDisconnect(ctx, &packet.Disconnect{Code: code.SessionTakenOver})
+	} else {
+		_ = c.Close()
+	}
+	select {
+	case <-c.done:
+		return
+	case <-ctx.Done():
+	}
+	_ = c.Close()
+	<-c.done
+}
+
+// keepAlive returns the keep alive time of the client,
+// DefaultKeepAlive is used if the client sends 0, and it is clamped to MaxKeepAlive.
+func keepAlive(clientKeepAlive uint16, config *config.Mqtt) uint16 {
+	k := clientKeepAlive
+	if k == 0 {
+		k = config.DefaultKeepAlive
+	}
+	if config.MaxKeepAlive != 0 && k > config.MaxKeepAlive {
+		k = config.MaxKeepAlive
+	}
+	return k
+}
+
+// maxInflight returns the maximum number of the outbound inflight messages of the client,
+// it is the minimum of MaxInflight in config and Receive Maximum of the v5 client, 0 means no limit.
+func maxInflight(conn *packet.Connect, config *config.Mqtt) uint16 {
+	m := config.MaxInflight
+	if props := conn.Properties; packet.IsVersion5(conn.Version) && props != nil && props.ReceiveMaximum != nil {
+		if r := *props.ReceiveMaximum; m == 0 || r < m {
+			m = r
+		}
+	}
+	if m == 0 {
+		m = packet.MaxPacketID
+	}
+	return m
+}
+
+// shutdown closes the connection because the server is stopping,
+// the pending packets are written before the connection is closed.
+// It returns when ctx is done even if the client does not read the pending packets.
+func (c *client) shutdown(ctx context.Context) {
+	c.setError(ErrServerShuttingDown)
+	if packet.IsVersion5(c.version) {
+		c.writeDisconnect(ctx, &packet.Disconnect{Code: code.ServerShuttingDown})
+		return
+	}
+	// v3 没有服务端 DISCONNECT 报文
+	c.write(ctx, closeConn{})
+}
+
+// setError records the first error which causes the connection to be closed.
+func (c *client) setError(err error) {
+	c.errOnce.Do(func() {
+		c.err = err
+	})
 }
 
 func (c *client) auth(ctx context.Context) bool {
@@ -260,45 +450,148 @@ func (c *client) auth(ctx context.Context) bool {
 
 	logger.Debug("开始认证")
 
-	var p packet.Packet
-	if c.IsConnected() {
-		if keepAlive := c.opt.KeepAlive; keepAlive != 0 { //KeepAlive
-			_ = c.clientConn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive/2+keepAlive) * time.Second))
-		}
+	if timeout := c.server.config.ConnectTimeout; timeout > 0 {
+		// 超时未完成认证（包括增强认证）则关闭连接
+		_ = c.clientConn.SetReadDeadline(time.Now().Add(timeout))
 	}
-
+	var p packet.Packet
 	p, err := c.packetReader.Read()
 	if err != nil {
-		if err != io.EOF && p != nil {
-			logger.Error("read error", zap.String("packet_type", reflect.TypeOf(p).String()))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			logger.Debug("连接超时，自动关闭", zap.String("IP", c.remoteAddr.String()))
+			return false
 		}
-		select {
-		case <-c.closed:
-			logger.Debug("客户端退出，关闭连接")
-		default:
-			logger.Debug("连接超时，自动关闭")
+		logger.Debug("read connect packet", zap.String("IP", c.remoteAddr.String()), zap.Error(err))
+		if e, ok := err.(*xerror.Error); ok && (e.Code == code.V3UnacceptableProtocolVersion || e.Code == code.V3IdentifierRejected) {
+			// 协议版本未知时使用 v3 的 CONNACK 响应 [MQTT-3.1.2-2] [MQTT-3.1.3-9]
+			c.write(ctx, &packet.Connack{Version: packet.Version311, Code: e.Code})
 		}
 		return false
 	}
 
 	if connect, ok := p.(*packet.Connect); ok {
-		if !c.connectAuthentication(ctx, connect) {
+		if serverName := c.serverName(); serverName != "" {
+			ctx = auth.NewContextWithServerName(ctx, serverName)
+		}
+		if state := c.tlsState(); state != nil {
+			ctx = auth.NewContextWithTLS(ctx, state)
+			if !c.applyIdentity(connect, state) {
+				logger.Debug("no identity in the client certificate", zap.String("IP", c.remoteAddr.String()))
+				c.write(ctx, connect.NewConnackPacket(code.NotAuthorized, false))
+				return false
+			}
+		}
+		if !c.checkClientId(connect) {
+			logger.Debug("zero length client id is not allowed", zap.String("IP", c.remoteAddr.String()))
+			c.write(ctx, connect.NewConnackPacket(code.ClientIdentifierNotValid, false))
+			return false
+		}
+		var authData []byte
+		if packet.IsVersion5(connect.Version) && connect.Properties != nil && connect.Properties.AuthMethod != nil {
+			authData, err = c.enhancedAuth(ctx, connect)
+			if err != nil {
+				logger.Debug("enhanced authentication failed", zap.Error(err))
+				c.write(ctx, connect.NewConnackPacket(authErrorCode(err), false))
+				return false
+			}
+			c.authMethod = string(connect.Properties.AuthMethod)
+		}
+		// 增强认证通过的客户端不再进行用户名密码认证
+		if c.authMethod == "" && c.listener.authenticator != nil {
+			if err = c.listener.authenticator.Authenticate(ctx, connect, c.remoteAddr); err != nil {
+				logger.Debug("authentication failed", zap.String("username", string(connect.Username)), zap.Error(err))
+				c.write(ctx, connect.NewConnackPacket(authErrorCode(err), false))
+				return false
+			}
+		}
+		if !c.connectAuthentication(ctx, connect, authData) {
 			logger.Debug("authentication failed", zap.String("IP", c.remoteAddr.String()))
 			return false
 		}
+		// 认证完成后由 readConn 按 Keep Alive 设置超时
+		_ = c.clientConn.SetReadDeadline(time.Time{})
 		return true
 	}
 
+	// 第一个报文必须是 CONNECT [MQTT-3.1.0-1]
 	logger.Debug("invalid package", zap.String("package", p.String()))
 	_ = c.Close()
 	logger.Debug("close connection", zap.String("IP", c.remoteAddr.String()))
 	return false
 }
 
+// checkClientId checks the zero length client id, a unique client id is assigned if it is allowed.
+func (c *client) checkClientId(connect *packet.Connect) bool {
+	if len(connect.ClientId) != 0 {
+		return true
+	}
+	if !c.server.config.AllowZeroLenClientId {
+		return false
+	}
+	// [MQTT-3.1.3-6]
+	connect.ClientId = []byte(newClientId())
+	c.clientIdAssigned = true
+	return true
+}
+
+// newClientId returns a random client id which is assigned to the client connecting with a zero length client id.
+func newClientId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "lighthouse-" + hex.EncodeToString(b)
+}
+
+// tlsState returns the TLS connection state of the client, nil if the client is not connected over TLS.
+func (c *client) tlsState() *tls.ConnectionState {
+	switch conn := c.clientConn.(type) {
+	case *tls.Conn:
+		state := conn.ConnectionState()
+		return &state
+	case *wsConn:
+		return conn.tlsState
+	}
+	return nil
+}
+
+// serverName returns the TLS SNI sent by the client, it is from the PROXY header if the TLS is terminated by the proxy.
+func (c *client) serverName() string {
+	if state := c.tlsState(); state != nil {
+		return state.ServerName
+	}
+	switch conn := c.clientConn.(type) {
+	case *proxyproto.Conn:
+		if h, err := conn.Header(); err == nil {
+			return h.Authority()
+		}
+	case *wsConn:
+		return conn.authority
+	}
+	return ""
+}
+
+// applyIdentity replaces the username or the client id with the identity of the client certificate.
+// It returns false if the identity is required but not found.
+func (c *client) applyIdentity(connect *packet.Connect, state *tls.ConnectionState) bool {
+	tlsConfig := c.listener.tlsConfig()
+	if tlsConfig == nil || tlsConfig.Identity == "" {
+		return true
+	}
+	identity := xtls.Identity(state, tlsConfig.Identity)
+	if identity == "" {
+		return false
+	}
+	if tlsConfig.IdentityAs == xtls.IdentityAsClientId {
+		connect.ClientId = []byte(identity)
+	} else {
+		connect.Username = []byte(identity)
+		connect.UsernameFlag = true
+	}
+	return true
+}
+
 func (c *client) readConn() {
 	defer func() {
-		// 关闭 in 通道
-		_ = c.Close()
+		// 关闭 in 通道，连接由 writeConn 关闭
 		close(c.in)
 	}()
 	go func() {
@@ -311,10 +604,9 @@ func (c *client) readConn() {
 	}()
 	for {
 		var p packet.Packet
-		if c.IsConnected() {
-			if keepAlive := c.opt.KeepAlive; keepAlive != 0 { //KeepAlive
-				_ = c.clientConn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive/2+keepAlive) * time.Second))
-			}
+		if keepAlive := c.opt.KeepAlive; keepAlive != 0 {
+			// 1.5 倍 Keep Alive 时间内未收到任何报文则关闭连接 [MQTT-3.1.2-22]
+			_ = c.clientConn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive) * time.Second * 3 / 2))
 		}
 		p, err := c.packetReader.Read()
 		if err != nil {
@@ -326,6 +618,16 @@ func (c *client) readConn() {
 				c.log.Debug("客户端退出，关闭连接")
 			default:
 				c.log.Debug("连接超时，自动关闭")
+				if ne, ok := err.(net.Error); ok && ne.Timeout() && c.opt.KeepAlive != 0 {
+					// 视为异常断开，遗嘱消息会被发布
+					err = ErrKeepAliveTimeout
+				}
+				if err != io.EOF {
+					c.setError(err)
+				}
+				if e, ok := err.(*xerror.Error); ok {
+					c.Disconnect(&packet.Disconnect{Code: e.Code})
+				}
 			}
 			return
 		}
@@ -334,7 +636,11 @@ func (c *client) readConn() {
 		//} else {
 		//	//c.log.Debug("Rec data", zap.String("packet", p.String()))
 		//}
-		c.in <- p
+		select {
+		case <-c.closed:
+			return
+		case c.in <- p:
+		}
 
 		// 等待连接认证完成
 		//c.waitConnection()
@@ -345,133 +651,246 @@ func (c *client) readConn() {
 func (c *client) writeConn() {
 
 	defer func() {
+		// 关闭连接，使 readConn 退出
+		_ = c.Close()
 	}()
 	for p := range c.out {
 		//c.log.Debug("Ret data", zap.String("packet", p.String()))
+		if _, ok := p.(closeConn); ok {
+			return
+		}
 		err := c.packetWriter.WritePacketAndFlush(p)
 		if err != nil {
 			return
 		}
+		if _, ok := p.(*packet.Disconnect); ok {
+			// 服务端发送 DISCONNECT 后必须关闭连接 [MQTT-3.14.4-1]
+			return
+		}
 	}
 	c.log.Debug("写入操作退出")
 
 }
 func (c *client) write(ctx context.Context, packet packet.Packet) {
 	c.log.WithContext(ctx).Debug("write packet", zap.String("packet", packet.String()))
-	c.out <- packet
+	c.outMu.RLock()
+	defer c.outMu.RUnlock()
+	if c.outClosed {
+		return
+	}
+	select {
+	case <-c.closed:
+	case <-ctx.Done():
+	case c.out <- packet:
+	}
 }
 
-//func (c *client) waitConnection() {
-//	<-c.connected
-//}
-
-func (c *client) connectionDone() {
-	close(c.connected)
+// closeOut closes the out channel, writeConn closes the connection after the remaining packets are written.
+// c.closed must be closed before to unblock the writers.
+func (c *client) closeOut() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outClosed = true
+	close(c.out)
 }
 
-func (c *client) connection() (ok bool) {
-	defer func() {
-		c.connectionDone()
-	}()
-	timeout := time.NewTimer(5 * time.Second)
-	defer timeout.Stop()
-	for {
-		select {
-		case p := <-c.in:
-			//c.log.Debug("从in通道中读出数据", zap.Any("packet", p))
-			if p == nil {
-				return
-			}
+// connectAuthentication 连接验证
+func (c *client) connectAuthentication(ctx context.Context, conn *packet.Connect, authData []byte) (ok bool) {
+	logger := c.log.WithContext(ctx)
 
-			switch conn := p.(type) {
-			case *packet.Connect:
-				if conn == nil {
-					//err := xerror.ErrProtocol
-					break
-				}
-				return c.connectAuthentication(context.Background(), conn)
-			default:
+	c.clientId = string(conn.ClientId)
+	c.version = conn.Version
+	c.opt = &ClientOption{
+		ClientId:            c.clientId,
+		Username:            string(conn.Username),
+		KeepAlive:           keepAlive(conn.KeepAlive, c.server.config),
+		MaxInflight:         maxInflight(conn, c.server.config),
+		ReceiveMax:          c.server.config.ReceiveMax,
+		ClientMaxPacketSize: packet.MaximumSize,
+		ServerMaxPacketSize: 0,
+		ClientTopicAliasMax: 0,
+		ServerTopicAliasMax: 0,
+		RequestProblemInfo:  true,
+		ServerName:          auth.ServerNameFromContext(ctx),
+	}
+	maxSessionExpiry := uint32(c.server.config.SessionExpiry / time.Second)
+	sessionExpiryClamped := false
+	if !packet.IsVersion5(c.version) && !conn.CleanSession {
+		c.opt.SessionExpiry = maxSessionExpiry
+	}
+	if props := conn.Properties; packet.IsVersion5(c.version) && props != nil {
+		if props.SessionExpiryInterval != nil {
+			c.opt.SessionExpiry = *props.SessionExpiryInterval
+			if c.opt.SessionExpiry > maxSessionExpiry {
+				c.opt.SessionExpiry = maxSessionExpiry
+				sessionExpiryClamped = true
 			}
-		case <-timeout.C:
-			return
 		}
-
+		if props.MaximumPacketSize != nil {
+			c.opt.ClientMaxPacketSize = *props.MaximumPacketSize
+		}
+		if props.TopicAliasMaximum != nil {
+			c.opt.ClientTopicAliasMax = *props.TopicAliasMaximum
+		}
+		if props.RequestProblemInfo != nil {
+			c.opt.RequestProblemInfo = *props.RequestProblemInfo == 1
+		}
 	}
 
-}
-
-// TODO 验证客户端连接
-// connectAuthentication 连接验证
-func (c *client) connectAuthentication(ctx context.Context, conn *packet.Connect) (ok bool) {
-	logger := c.log.WithContext(ctx)
+	if err := c.server.hooks.onConnect(ctx, c, conn); err != nil {
+		logger.Debug("connect rejected by hook", zap.String("clientId", c.clientId), zap.Error(err))
+		c.write(ctx, conn.NewConnackPacket(hookErrorCode(err), false))
+		return false
+	}
 
-	// 根据报文进行认证
-	var connack *packet.Connack
-	connack = conn.NewConnackPacket(code.Success, true)
-	c.clientId = string(conn.ClientId)
-	logger.Debug("认证成功", zap.String("clientId", c.clientId))
+	logger.Debug("认证成功", zap.String("clientId", c.clientId), zap.String("serverName", c.opt.ServerName))
 
-	c.status = Connected
 	var msg *message.Message
+	var willDelay uint32
 	if conn.WillFlag {
-		msg = &message.Message{
-			Dup:                    false,
-			QoS:                    conn.WillQoS,
-			Retained:               conn.WillRetain,
-			Topic:                  string(conn.WillTopic),
-			Payload:                conn.WillMessage,
-			PacketId:               0,
-			ContentType:            "",
-			CorrelationData:        nil,
-			MessageExpiry:          0,
-			PayloadFormat:          packet.PayloadFormatBytes,
-			ResponseTopic:          "",
-			SubscriptionIdentifier: nil,
+		// [MQTT-3.2.2-12]
+		if packet.IsVersion5(c.version) && conn.WillRetain && !c.server.config.RetainAvailable {
+			c.write(ctx, conn.NewConnackPacket(code.RetainNotSupported, false))
+			return false
+		}
+		msg = message.FromPublish(&packet.Publish{
+			Version:    c.version,
+			QoS:        conn.WillQoS,
+			Retain:     conn.WillRetain,
+			TopicName:  conn.WillTopic,
+			Payload:    conn.WillMessage,
+			Properties: conn.WillProperties,
+		})
+		if props := conn.WillProperties; packet.IsVersion5(c.version) && props != nil && props.WillDelayInterval != nil {
+			willDelay = *props.WillDelayInterval
+		}
+	}
+	oldClient, ok := c.server.registerClient(c)
+	if !ok {
+		logger.Debug("server is stopping", zap.String("clientId", c.clientId))
+		c.write(ctx, conn.NewConnackPacket(code.ServerUnavailable, false))
+		return false
+	}
+	if oldClient != nil {
+		// 踢掉旧连接，等待旧连接完成会话处理后再恢复会话
+		logger.Debug("session taken over", zap.String("clientId", c.clientId), zap.String("old", oldClient.remoteAddr.String()))
+		oldClient.kick()
+	}
+	// 会话结束时立即发布等待中的遗嘱消息，会话恢复时取消
+	c.server.stopWill(c.clientId, conn.CleanSession)
+	old, err := c.server.sessionStore.Get(ctx, c.clientId)
+	if err != nil {
+		logger.Error("get session", zap.Error(err))
+		return false
+	}
+	hasOld := old != nil && old.ClientId != ""
+	resumed := !conn.CleanSession && hasOld
+	if conn.CleanSession && hasOld {
+		// 清除旧会话
+		c.server.terminateSession(ctx, c.clientId, TakenOver)
+	}
+
+	connack := conn.NewConnackPacket(code.Success, resumed)
+	if packet.IsVersion5(c.version) {
+		connack.Properties = &packet.Properties{}
+		if sessionExpiryClamped {
+			connack.Properties.SessionExpiryInterval = &c.opt.SessionExpiry
+		}
+		if c.authMethod != "" {
+			connack.Properties.AuthMethod = []byte(c.authMethod)
+			connack.Properties.AuthData = authData
+		}
+		if !c.server.config.RetainAvailable {
+			retainAvailable := byte(0)
+			connack.Properties.RetainAvailable = &retainAvailable
+		}
+		if !c.server.config.SharedSubAvailable {
+			sharedSubAvailable := byte(0)
+			connack.Properties.SharedSubAvailable = &sharedSubAvailable
+		}
+		if c.opt.ReceiveMax != 0 {
+			connack.Properties.ReceiveMaximum = &c.opt.ReceiveMax
+		}
+		if c.clientIdAssigned {
+			// [MQTT-3.2.2-16]
+			connack.Properties.AssignedClientId = conn.ClientId
+		}
+		if c.opt.KeepAlive != conn.KeepAlive {
+			// 客户端必须使用服务端指定的 Keep Alive [MQTT-3.2.2-22]
+			connack.Properties.ServerKeepAlive = &c.opt.KeepAlive
 		}
 	}
 	c.session = &session.Session{
 		ClientId:          c.clientId,
 		Will:              msg,
-		WillDelayInterval: 0,
+		WillDelayInterval: willDelay,
 		ConnectedAt:       time.Now(),
-		ExpiryInterval:    0,
+		ExpiryInterval:    c.opt.SessionExpiry,
 	}
 	// client session
-	err := c.server.sessionStore.Set(ctx, c.session)
+	err = c.server.sessionStore.Set(ctx, c.session)
 	if err != nil {
 		logger.Panic("redis err", zap.Error(err))
 	}
-	if !conn.CleanSession {
-		// 获取订阅记录
-		subscriptions := subscription.GetClientSubscriptions(ctx, c.server.subscriptionStore, string(conn.ClientId), subscription.TypeAll)
-
-		logger.Info("all subscriptions", zap.Any("subscriptions", subscriptions))
 
+	var notifier *queueNotifier
+	c.queueStore, notifier, err = c.server.getQueueStore(c.clientId)
+	if err != nil {
+		logger.Error("get queue store", zap.Error(err))
+		return false
 	}
-
-	c.version = conn.Version
-	c.opt = &ClientOption{
-		ClientId:  c.clientId,
-		Username:  string(conn.Username),
-		KeepAlive: conn.KeepAlive,
-		//SessionExpiry:       conn,
-		MaxInflight:         0,
-		ReceiveMax:          0,
-		ClientMaxPacketSize: 0,
-		ServerMaxPacketSize: 0,
-		ClientTopicAliasMax: 0,
-		ServerTopicAliasMax: 0,
-		RequestProblemInfo:  false,
+	err = c.queueStore.Init(ctx, &queue.InitOptions{
+		CleanStart:     conn.CleanSession,
+		Version:        c.version,
+		ReadBytesLimit: c.opt.ClientMaxPacketSize,
+		Notifier:       notifier,
+	})
+	if err != nil {
+		logger.Error("init queue store", zap.Error(err))
+		return false
+	}
+	c.unackStore, err = c.server.getUnackStore(c.clientId)
+	if err != nil {
+		logger.Error("get unack store", zap.Error(err))
+		return false
+	}
+	if err = c.unackStore.Init(ctx, conn.CleanSession); err != nil {
+		logger.Error("init unack store", zap.Error(err))
+		return false
 	}
 	c.newPacketIdLimiter(c.opt.MaxInflight)
+	c.receiving = make(map[packet.Id]struct{})
+	if !conn.CleanSession {
+		// 恢复的会话中未释放的 QoS 2 消息仍占用 Receive Maximum 配额
+		ids, err := c.unackStore.List(ctx)
+		if err != nil {
+			logger.Error("list unack packet ids", zap.Error(err))
+			return false
+		}
+		for _, id := range ids {
+			c.receiving[id] = struct{}{}
+		}
+	}
+	c.status = Connected
+	if resumed {
+		c.server.hooks.onSessionResumed(ctx, c)
+	} else {
+		c.server.hooks.onSessionCreated(ctx, c)
+	}
 	c.write(ctx, connack)
+	c.server.hooks.onConnected(ctx, c)
 	return true
 }
 
 func (c *client) handleConn() {
 	defer func() {
 		close(c.closed)
-		close(c.out)
+		// 唤醒 pollMessageHandler
+		c.limit.close()
+		_ = c.queueStore.Close()
+		_ = c.unackStore.Close()
+		c.pollWg.Wait()
+		c.closeOut()
 	}()
 	var err *xerror.Error
 	// in 通道关闭时，自动退出
@@ -483,15 +902,32 @@ func (c *client) handleConn() {
 			c.handlePingreq(packetData)
 		case *packet.Pubrel:
 			c.handlePubrel(packetData)
+		case *packet.Puback:
+			c.handlePuback(packetData)
+		case *packet.Pubrec:
+			c.handlePubrec(packetData)
+		case *packet.Pubcomp:
+			c.handlePubcomp(packetData)
 		case *packet.Subscribe:
 			c.handleSubscribe(packetData)
 		case *packet.Unsubscribe:
 			c.handleUnsubscribe(packetData)
+		case *packet.Auth:
+			err = c.handleAuth(packetData)
+		case *packet.Connect:
+			// 第二个 CONNECT 报文视为协议违规 [MQTT-3.1.0-2]
+			err = xerror.ErrProtocol
 		case *packet.Disconnect:
-			break
+			// 客户端主动断开连接，除 v5 的 0x04 外不发送遗嘱消息 [MQTT-3.14.4-3]
+			if packetData.Code != code.DisconnectWithWillMessage {
+				c.cleanWillFlag = true
+			}
+			return
 		default:
 		}
 		if err != nil {
+			c.setError(err)
+			c.Disconnect(&packet.Disconnect{Code: err.Code})
 			break
 		}
 	}
@@ -505,12 +941,64 @@ func (c *client) handlePublish(publish *packet.Publish) *xerror.Error {
 	ctx, span, logger := c.getTraceLog("publish")
 	defer span.End()
 	logger.Debug("received publish packet", zap.String("packet", publish.String()))
+	// Topic Alias Maximum 未在 CONNACK 中声明（默认为 0），客户端不能使用 Topic Alias
+	if packet.IsVersion5(c.version) && publish.Properties != nil && publish.Properties.TopicAlias != nil {
+		return xerror.NewError(code.TopicAliasInvalid)
+	}
+	if !c.checkReceiveMax(publish) {
+		logger.Debug("receive maximum exceeded", zap.Uint16("receiveMax", c.opt.ReceiveMax))
+		return ErrReceiveMaxExceeded
+	}
+	if publish.QoS == packet.QoS2 {
+		// 收到 PUBREL 前，相同 Packet Identifier 的 PUBLISH 只响应 PUBREC，不再转发 [MQTT-4.3.3-9]
+		exists, err := c.unackStore.Set(ctx, publish.PacketId)
+		if err != nil {
+			logger.Error("set unack packet id", zap.Uint16("packetId", publish.PacketId), zap.Error(err))
+			return xerror.NewError(code.UnspecifiedError)
+		}
+		if exists {
+			logger.Debug("duplicate qos2 publish", zap.Uint16("packetId", publish.PacketId), zap.Bool("dup", publish.Dup))
+			c.write(ctx, publish.CreatePubrec())
+			return nil
+		}
+	}
+	// 消息被拒绝时，v5 客户端返回对应的 Reason Code，v3 客户端静默丢弃
+	reason := code.Success
+	msg := message.FromPublish(publish)
+	// 钩子可能修改主题，ACL 检查最终的主题
+	if err := c.server.hooks.onMsgArrived(ctx, c, msg); err != nil {
+		logger.Debug("publish dropped by hook", zap.String("topic", msg.Topic), zap.Error(err))
+		reason = hookErrorCode(err)
+	} else if !c.checkPublish(msg.Topic) {
+		logger.Debug("publish not authorized", zap.String("topic", msg.Topic))
+		reason = code.NotAuthorized
+	}
+	if reason == code.Success && msg.Retained {
+		if err := c.retainMessage(msg); err != nil {
+			return err
+		}
+	}
+	ackCode := code.Success
+	if packet.IsVersion5(c.version) {
+		ackCode = reason
+	}
 	var ackPacket packet.Packet
 	switch publish.QoS {
 	case packet.QoS1:
-		ackPacket = publish.CreatePuback()
+		puback := publish.CreatePuback()
+		puback.Code = ackCode
+		ackPacket = puback
 	case packet.QoS2:
-		ackPacket = publish.CreatePubrec()
+		pubrec := publish.CreatePubrec()
+		pubrec.Code = ackCode
+		ackPacket = pubrec
+		if ackCode >= code.UnspecifiedError {
+			// Reason Code 大于等于 0x80 时 QoS 2 流程结束，客户端不会发送 PUBREL
+			delete(c.receiving, publish.PacketId)
+			if err := c.unackStore.Remove(ctx, publish.PacketId); err != nil {
+				logger.Error("remove unack packet id", zap.Uint16("packetId", publish.PacketId), zap.Error(err))
+			}
+		}
 	}
 
 	if ackPacket != nil {
@@ -518,9 +1006,54 @@ func (c *client) handlePublish(publish *packet.Publish) *xerror.Error {
 		c.write(ctx, ackPacket)
 	}
 
+	if reason == code.Success {
+		c.server.deliverMessage(ctx, c.clientId, msg)
+	}
 	return nil
 }
 
+// checkReceiveMax records the inbound QoS 2 publication until it is released by the PUBREL,
+// it returns false if the client sends more QoS 1 and QoS 2 publications than the Receive Maximum [MQTT-3.3.4-9].
+// The QoS 1 publication is acknowledged immediately, so only the QoS 2 publications are recorded.
+func (c *client) checkReceiveMax(publish *packet.Publish) bool {
+	max := c.opt.ReceiveMax
+	if max == 0 || publish.QoS == packet.QoS0 {
+		return true
+	}
+	if publish.QoS == packet.QoS2 {
+		if _, ok := c.receiving[publish.PacketId]; ok {
+			// 重传的 PUBLISH 不占用额外的配额
+			return true
+		}
+	}
+	if len(c.receiving) >= int(max) {
+		return false
+	}
+	if publish.QoS == packet.QoS2 {
+		c.receiving[publish.PacketId] = struct{}{}
+	}
+	return true
+}
+
+// aclClient returns the client info which is used to check the ACL,
+// it is created on the first check and reused, so the ACL can cache the rule topic filters of the client.
+func (c *client) aclClient() *acl.Client {
+	if c.aclInfo == nil {
+		c.aclInfo = &acl.Client{ClientId: c.clientId, Username: c.opt.Username, RemoteAddr: c.remoteAddr}
+	}
+	return c.aclInfo
+}
+
+// checkPublish returns whether the client is allowed to publish the topic.
+func (c *client) checkPublish(topicName string) bool {
+	return c.listener.acl == nil || c.listener.acl.CheckPublish(c.aclClient(), topicName)
+}
+
+// checkSubscribe returns whether the client is allowed to subscribe the topic filter.
+func (c *client) checkSubscribe(topicFilter string) bool {
+	return c.listener.acl == nil || c.listener.acl.CheckSubscribe(c.aclClient(), topicFilter)
+}
+
 func (c *client) handlePingreq(pingreq *packet.Pingreq) {
 	ctx, span, logger := c.getTraceLog("ping request")
 	defer span.End()
@@ -533,7 +1066,80 @@ func (c *client) handlePubrel(pubrel *packet.Pubrel) {
 	defer span.End()
 
 	logger.Debug("received publish release packet", zap.String("packet", pubrel.String()))
-	c.write(ctx, pubrel.CreatePubcomp())
+	pubcomp := pubrel.CreatePubcomp()
+	delete(c.receiving, pubrel.PacketId)
+	exists, err := c.unackStore.Exists(ctx, pubrel.PacketId)
+	if err != nil {
+		logger.Error("get unack packet id", zap.Uint16("packetId", pubrel.PacketId), zap.Error(err))
+	}
+	if exists {
+		if err = c.unackStore.Remove(ctx, pubrel.PacketId); err != nil {
+			logger.Error("remove unack packet id", zap.Uint16("packetId", pubrel.PacketId), zap.Error(err))
+		}
+	} else if packet.IsVersion5(c.version) {
+		pubcomp.Code = code.PacketIDNotFound
+	}
+	c.write(ctx, pubcomp)
+}
+
+// handlePuback handles the PUBACK of the QoS 1 message delivered to the client, the message is removed from the queue.
+func (c *client) handlePuback(puback *packet.Puback) {
+	ctx, span, logger := c.getTraceLog("publish ack")
+	defer span.End()
+
+	logger.Debug("received publish ack packet", zap.String("packet", puback.String()))
+	if packet.IsVersion5(c.version) && puback.Code >= code.UnspecifiedError {
+		// 失败的 Reason Code 同样表示消息投递结束
+		logger.Debug("message is not accepted", zap.Uint16("packetId", puback.PacketId), zap.Uint8("code", puback.Code))
+	}
+	c.ackDone(ctx, puback.PacketId)
+}
+
+// handlePubrec handles the PUBREC of the QoS 2 message delivered to the client,
+// the message is replaced with the PUBREL in the queue and the PUBREL is sent.
+func (c *client) handlePubrec(pubrec *packet.Pubrec) {
+	ctx, span, logger := c.getTraceLog("publish received")
+	defer span.End()
+
+	logger.Debug("received publish received packet", zap.String("packet", pubrec.String()))
+	if packet.IsVersion5(c.version) && pubrec.Code >= code.UnspecifiedError {
+		// Reason Code 大于等于 0x80 时 QoS 2 流程结束，不再发送 PUBREL [MQTT-4.3.3-4]
+		logger.Debug("message is not accepted", zap.Uint16("packetId", pubrec.PacketId), zap.Uint8("code", pubrec.Code))
+		c.ackDone(ctx, pubrec.PacketId)
+		return
+	}
+	pubrel := pubrec.CreateNewPubrel()
+	replaced, err := c.queueStore.Replace(ctx, &queue.Element{
+		At:      time.Now(),
+		Message: &queue.Pubrel{PacketID: pubrec.PacketId},
+	})
+	if err != nil {
+		logger.Error("replace publish with pubrel", zap.Uint16("packetId", pubrec.PacketId), zap.Error(err))
+	}
+	if !replaced && packet.IsVersion5(c.version) {
+		pubrel.Code = code.PacketIDNotFound
+	}
+	c.write(ctx, pubrel)
+}
+
+// handlePubcomp handles the PUBCOMP of the QoS 2 message delivered to the client, the PUBREL is removed from the queue.
+func (c *client) handlePubcomp(pubcomp *packet.Pubcomp) {
+	ctx, span, logger := c.getTraceLog("publish complete")
+	defer span.End()
+
+	logger.Debug("received publish complete packet", zap.String("packet", pubcomp.String()))
+	if packet.IsVersion5(c.version) && pubcomp.Code != code.Success {
+		logger.Debug("publish complete failed", zap.Uint16("packetId", pubcomp.PacketId), zap.Uint8("code", pubcomp.Code))
+	}
+	c.ackDone(ctx, pubcomp.PacketId)
+}
+
+// ackDone removes the acknowledged message from the queue and releases the packet id for the new messages.
+func (c *client) ackDone(ctx context.Context, packetId packet.Id) {
+	if err := c.queueStore.Remove(ctx, packetId); err != nil {
+		c.log.WithContext(ctx).Error("remove message from queue", zap.Uint16("packetId", packetId), zap.Error(err))
+	}
+	c.limit.release(packetId)
 }
 
 func (c *client) handleSubscribe(subscribe *packet.Subscribe) {
@@ -543,17 +1149,38 @@ func (c *client) handleSubscribe(subscribe *packet.Subscribe) {
 	logger.Debug("received subscribe packet", zap.String("packet", subscribe.String()))
 
 	var subs = make([]*sub.Subscription, 0, len(subscribe.Topics))
+	var codes = make([]code.Code, 0, len(subscribe.Topics))
 
+	var subId uint32
+	if subscribe.Properties != nil && len(subscribe.Properties.SubscriptionIdentifier) != 0 {
+		subId = subscribe.Properties.SubscriptionIdentifier[0]
+	}
 	for _, topic := range subscribe.Topics {
-		subs = append(subs, &sub.Subscription{
-			//ShareName:         topic.Name,
-			TopicFilter: topic.Name,
-			//ID:                subscribe.PacketId,
-			QoS:               topic.QoS,
-			NoLocal:           topic.NoLocal,
-			RetainAsPublished: topic.RetainAsPublished,
-			RetainHandling:    topic.RetainHandling,
-		})
+		if err := c.server.hooks.onSubscribe(ctx, c, topic); err != nil {
+			logger.Debug("subscribe rejected by hook", zap.String("topic", topic.Name), zap.Error(err))
+			codes = append(codes, c.subscribeFailure(hookErrorCode(err)))
+			continue
+		}
+		// 钩子可能修改主题，检查最终订阅的主题
+		if !c.checkSubscribe(topic.Name) {
+			logger.Debug("subscribe not authorized", zap.String("topic", topic.Name))
+			codes = append(codes, c.subscribeFailure(code.NotAuthorized))
+			continue
+		}
+		s := subscription.FromTopic(*topic, subId)
+		if strings.HasPrefix(topic.Name, "$share/") && (s.ShareName == "" || s.TopicFilter == "" || strings.ContainsAny(s.ShareName, "+#")) {
+			// ShareName 不能为空且不能包含通配符 [MQTT-4.8.2-1] [MQTT-4.8.2-2]
+			logger.Debug("invalid shared subscription", zap.String("topic", topic.Name))
+			codes = append(codes, c.subscribeFailure(code.TopicFilterInvalid))
+			continue
+		}
+		if s.ShareName != "" && !c.server.config.SharedSubAvailable {
+			logger.Debug("shared subscription not supported", zap.String("topic", topic.Name))
+			codes = append(codes, c.subscribeFailure(code.SharedSubNotSupported))
+			continue
+		}
+		codes = append(codes, topic.QoS)
+		subs = append(subs, s)
 	}
 	subscribeResult, err := c.subscriptionStore.Subscribe(ctx, c.clientId, subs...)
 	if err != nil {
@@ -563,11 +1190,23 @@ func (c *client) handleSubscribe(subscribe *packet.Subscribe) {
 	} else {
 		logger.Info("", zap.Any("subscribeResult", subscribeResult))
 	}
+	for _, s := range subs {
+		c.server.hooks.onSubscribed(ctx, c, s)
+	}
 	c.write(ctx, &packet.Suback{
 		Version:  subscribe.Version,
 		PacketId: subscribe.PacketId,
-		Payload:  make([]code.Code, len(subscribe.Topics)),
+		Payload:  codes,
 	})
+	c.deliverRetained(ctx, subscribeResult)
+}
+
+// subscribeFailure returns the SUBACK Reason Code of the failed subscription.
+func (c *client) subscribeFailure(cd code.Code) code.Code {
+	if packet.IsVersion5(c.version) {
+		return cd
+	}
+	return packet.SubscribeFailure
 }
 
 func (c *client) handleUnsubscribe(unsubscribe *packet.Unsubscribe) {
@@ -575,10 +1214,25 @@ func (c *client) handleUnsubscribe(unsubscribe *packet.Unsubscribe) {
 	defer span.End()
 	logger.Debug("received unsubscribe packet", zap.String("packet", unsubscribe.String()))
 
-	c.write(ctx, &packet.Unsuback{
+	unsuback := &packet.Unsuback{
 		Version:  unsubscribe.Version,
 		PacketId: unsubscribe.PacketId,
-	})
+	}
+	for _, topicFilter := range unsubscribe.Topics {
+		cd := code.Success
+		if err := c.server.hooks.onUnsubscribe(ctx, c, topicFilter); err != nil {
+			logger.Debug("unsubscribe rejected by hook", zap.String("topic", topicFilter), zap.Error(err))
+			cd = hookErrorCode(err)
+		} else if err = c.subscriptionStore.Unsubscribe(ctx, c.clientId, topicFilter); err != nil {
+			logger.Error("unsubscribe", zap.String("topic", topicFilter), zap.Error(err))
+			cd = code.UnspecifiedError
+		}
+		if packet.IsVersion5(unsubscribe.Version) {
+			// MQTT v5 的 UNSUBACK 必须为每个主题过滤器返回一个 Reason Code
+			unsuback.Payload = append(unsuback.Payload, cd)
+		}
+	}
+	c.write(ctx, unsuback)
 }
 
 func (c *client) pollMessageHandler() {
@@ -587,7 +1241,9 @@ func (c *client) pollMessageHandler() {
 		if re := recover(); re != nil {
 			err = errors.New(fmt.Sprint(re))
 		}
-		//c.setError(err)
+		if err != nil {
+			c.setError(err)
+		}
 	}()
 	cont := true
 	for cont {
@@ -628,6 +1284,7 @@ func (c *client) pollNewMessages(ids []packet.Id) (unused []packet.Id, err error
 				ids = ids[1:]
 			}
 			c.write(context.Background(), message.ToPublish(m.Message, c.version))
+			c.server.hooks.onDelivered(context.Background(), c, m.Message)
 		case *queue.Pubrel:
 		}
 	}
@@ -651,12 +1308,24 @@ func (c *client) pollInFlights() (bool, error) {
 			c.limit.markUsedLocked(id)
 			c.write(context.Background(), message.ToPublish(m.Message, c.version))
 		case *queue.Pubrel:
-			c.write(context.Background(), &packet.Pubrel{PacketId: id})
+			c.limit.markUsedLocked(id)
+			c.write(context.Background(), &packet.Pubrel{Version: c.version, PacketId: id})
 		}
 	}
-	return false, nil
+	return true, nil
 }
 
 func (c *client) newPacketIdLimiter(limit uint16) {
 	c.limit = newPacketIDLimiter(limit)
 }
+
+// Stats returns the statistics of the outbound messages of the client.
+func (c *client) Stats() ClientStats {
+	c.server.mu.RLock()
+	n, ok := c.server.queueNotifiers[c.clientId]
+	c.server.mu.RUnlock()
+	if !ok {
+		return ClientStats{}
+	}
+	return n.stats()
+}
@@ -0,0 +1,103 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestServer_connectTimeout(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.ConnectTimeout = 200 * time.Millisecond
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	c := dial(t, s)
+	start := time.Now()
+	_ = c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err := c.conn.Read(make([]byte, 1))
+	a.Equal(io.EOF, err)
+	a.GreaterOrEqual(time.Since(start), mqtt.ConnectTimeout)
+}
+
+func TestServer_secondConnect(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("second", true, nil).Code)
+	c.write(&packet.Connect{
+		Version:       packet.Version5,
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(packet.Version5),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: true},
+		ClientId:      []byte("second"),
+	})
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.ProtocolError, disconnect.Code)
+}
+
+func TestServer_protocolNameMismatch(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	c.write(&packet.Connect{
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQIsdp"),
+		ProtocolLevel: byte(packet.Version311),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: true},
+		ClientId:      []byte("mismatch"),
+	})
+	connack, ok := c.read().(*packet.Connack)
+	a.True(ok)
+	a.Equal(code.V3UnacceptableProtocolVersion, connack.Code)
+}
+
+func TestServer_zeroLenClientId(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	connack := c.connectV5("", true, nil)
+	a.Equal(code.Success, connack.Code)
+	a.NotEmpty(connack.Properties.AssignedClientId)
+	s.mu.RLock()
+	_, ok := s.clients[string(connack.Properties.AssignedClientId)]
+	s.mu.RUnlock()
+	a.True(ok)
+
+	c = dial(t, s)
+	a.Equal(code.Success, c.connect("", true).Code)
+
+	mqtt := config.DefaultMqtt
+	mqtt.AllowZeroLenClientId = false
+	s = newTestServer(t, WithMqtt(&mqtt))
+
+	c = dial(t, s)
+	a.Equal(code.ClientIdentifierNotValid, c.connectV5("", true, nil).Code)
+	c = dial(t, s)
+	a.Equal(code.V3IdentifierRejected, c.connect("", true).Code)
+}
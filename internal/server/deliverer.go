@@ -17,10 +17,105 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// DeliveryModeOverlap delivers one message for each matching subscription.
+	DeliveryModeOverlap = "overlap"
+	// DeliveryModeOnlyOnce delivers one message respecting the maximum QoS of all the matching subscriptions.
+	DeliveryModeOnlyOnce = "onlyonce"
+)
+
+var (
+	ErrNotConnected = errors.New("client is not connected")
 )
 
 // Deliverer 表示具备投递信息功能的一类对象
 type Deliverer interface {
 	Deliver(message message.Message) error
 }
+
+// newDeliverMessages returns the messages that should be delivered to the client for the given subscriptions.
+// srcClientId is the client id of the publisher, it is used by the No Local option, set to "" if the message
+// is not published by a client.
+func (s *server) newDeliverMessages(srcClientId, clientId string, msg *message.Message, subs []*sub.Subscription) []*message.Message {
+	var msgs []*message.Message
+	var onlyOnce *message.Message
+	for _, subscription := range subs {
+		// [MQTT-3.8.3-3]
+		if subscription.NoLocal && srcClientId == clientId {
+			continue
+		}
+		m := msg.Copy()
+		m.Dup = false
+		m.PacketId = 0
+		// [MQTT-3.3.1-12]
+		if !subscription.RetainAsPublished {
+			m.Retained = false
+		}
+		if m.QoS > subscription.QoS {
+			m.QoS = subscription.QoS
+		}
+		m.SubscriptionIdentifier = nil
+		if subscription.ID != 0 {
+			m.SubscriptionIdentifier = []uint32{subscription.ID}
+		}
+		if s.config.DeliveryMode == DeliveryModeOverlap {
+			msgs = append(msgs, m)
+			continue
+		}
+		if onlyOnce == nil {
+			onlyOnce = m
+			continue
+		}
+		if m.QoS > onlyOnce.QoS {
+			onlyOnce.QoS = m.QoS
+		}
+		if m.Retained {
+			onlyOnce.Retained = true
+		}
+		onlyOnce.SubscriptionIdentifier = append(onlyOnce.SubscriptionIdentifier, m.SubscriptionIdentifier...)
+	}
+	if onlyOnce != nil {
+		msgs = append(msgs, onlyOnce)
+	}
+	return msgs
+}
+
+// messageExpiry returns the expiry time of the message, zero time means never expire.
+func (s *server) messageExpiry(now time.Time, msg *message.Message) time.Time {
+	expiry := s.config.MessageExpiry
+	if msg.MessageExpiry != 0 {
+		if e := time.Duration(msg.MessageExpiry) * time.Second; expiry == 0 || e < expiry {
+			expiry = e
+		}
+	}
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return now.Add(expiry)
+}
+
+// enqueue adds the messages into the queue of the client.
+func (s *server) enqueue(ctx context.Context, clientId string, q queue.Queue, msgs ...*message.Message) error {
+	now := time.Now()
+	for _, m := range msgs {
+		err := q.Add(ctx, &queue.Element{
+			At:      now,
+			Expiry:  s.messageExpiry(now, m),
+			Message: &queue.Publish{Message: m},
+		})
+		if err != nil {
+			s.log.WithContext(ctx).Error("add message to queue", zap.String("clientId", clientId), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
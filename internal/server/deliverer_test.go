@@ -0,0 +1,94 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"testing"
+	"time"
+)
+
+func Test_server_newDeliverMessages(t *testing.T) {
+	msg := &message.Message{
+		QoS:      packet.QoS2,
+		Retained: true,
+		Topic:    "a/b",
+		Payload:  []byte("payload"),
+		PacketId: 10,
+	}
+	subs := []*sub.Subscription{
+		{TopicFilter: "a/+", QoS: packet.QoS0, ID: 1},
+		{TopicFilter: "a/#", QoS: packet.QoS1, ID: 2, RetainAsPublished: true},
+		{TopicFilter: "a/b", QoS: packet.QoS2, NoLocal: true},
+	}
+	tests := []struct {
+		name        string
+		mode        string
+		srcClientId string
+		want        []*message.Message
+	}{
+		{
+			name:        "onlyonce",
+			mode:        DeliveryModeOnlyOnce,
+			srcClientId: "publisher",
+			want: []*message.Message{
+				{QoS: packet.QoS2, Retained: true, Topic: "a/b", Payload: []byte("payload"), SubscriptionIdentifier: []uint32{1, 2}},
+			},
+		},
+		{
+			name:        "onlyonce no local",
+			mode:        DeliveryModeOnlyOnce,
+			srcClientId: "subscriber",
+			want: []*message.Message{
+				{QoS: packet.QoS1, Retained: true, Topic: "a/b", Payload: []byte("payload"), SubscriptionIdentifier: []uint32{1, 2}},
+			},
+		},
+		{
+			name:        "overlap",
+			mode:        DeliveryModeOverlap,
+			srcClientId: "publisher",
+			want: []*message.Message{
+				{QoS: packet.QoS0, Topic: "a/b", Payload: []byte("payload"), SubscriptionIdentifier: []uint32{1}},
+				{QoS: packet.QoS1, Retained: true, Topic: "a/b", Payload: []byte("payload"), SubscriptionIdentifier: []uint32{2}},
+				{QoS: packet.QoS2, Topic: "a/b", Payload: []byte("payload")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{config: &config.Mqtt{DeliveryMode: tt.mode}}
+			assert.Equal(t, tt.want, s.newDeliverMessages(tt.srcClientId, "subscriber", msg, subs))
+		})
+	}
+}
+
+func Test_server_messageExpiry(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	s := &server{config: &config.Mqtt{MessageExpiry: time.Minute}}
+	a.Equal(now.Add(time.Minute), s.messageExpiry(now, &message.Message{}))
+	a.Equal(now.Add(10*time.Second), s.messageExpiry(now, &message.Message{MessageExpiry: 10}))
+	a.Equal(now.Add(time.Minute), s.messageExpiry(now, &message.Message{MessageExpiry: 100}))
+
+	s.config.MessageExpiry = 0
+	a.True(s.messageExpiry(now, &message.Message{}).IsZero())
+	a.Equal(now.Add(100*time.Second), s.messageExpiry(now, &message.Message{MessageExpiry: 100}))
+}
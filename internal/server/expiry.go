@@ -0,0 +1,75 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	sess "github.com/yunqi/lighthouse/internal/session"
+	"go.uber.org/zap"
+	"time"
+)
+
+// sessionExpiryCheck removes the expired sessions every SessionExpiryCheckInterval until the server is stopped.
+func (s *server) sessionExpiryCheck() {
+	interval := s.config.SessionExpiryCheckInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.exit:
+			return
+		case now := <-ticker.C:
+			s.removeExpiredSessions(now)
+		}
+	}
+}
+
+// removeExpiredSessions removes the expired sessions of the offline clients.
+func (s *server) removeExpiredSessions(now time.Time) {
+	ctx, span := s.tracer.Start(context.Background(), "session expiry check")
+	defer span.End()
+	logger := s.log.WithContext(ctx)
+
+	var expired []string
+	err := s.sessionStore.Iterate(ctx, func(session *sess.Session) bool {
+		if session.IsExpired(now) && !s.isOnline(session.ClientId) {
+			expired = append(expired, session.ClientId)
+		}
+		return true
+	})
+	if err != nil {
+		logger.Error("iterate sessions", zap.Error(err))
+		return
+	}
+	for _, clientId := range expired {
+		logger.Debug("session expired", zap.String("clientId", clientId))
+		// 遗嘱延迟时间超过会话有效期的遗嘱消息在会话过期时发布
+		s.stopWill(clientId, true)
+		s.terminateSession(ctx, clientId, Expired)
+	}
+}
+
+// isOnline returns whether the client is connected.
+func (s *server) isOnline(clientId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.clients[clientId]
+	return ok
+}
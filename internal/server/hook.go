@@ -0,0 +1,220 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"net"
+)
+
+const (
+	// NormalTermination indicates the session is terminated because the client is disconnected and the session does not need to be kept.
+	NormalTermination SessionTerminatedReason = iota
+	// TakenOver indicates the session is taken over by a new connection with CleanStart=true.
+	TakenOver
+	// Expired indicates the session is expired.
+	Expired
+)
+
+var _ Hook = HookBase{}
+
+type (
+	// SessionTerminatedReason is the reason why the session is terminated.
+	SessionTerminatedReason byte
+
+	// Hook is the extension point around the client lifecycle, such as auditing, payload enrichment and custom auth.
+	// Hooks are called in the order they are registered by WithHook.
+	// For the hooks which return an error, the first non-nil error short-circuits the chain,
+	// if the error is a *xerror.Error, its Code is used as the Reason Code.
+	// Embed HookBase to implement only the needed methods.
+	Hook interface {
+		// OnAccept is called when a new connection is accepted, the connection will be closed if it returns false.
+		OnAccept(ctx context.Context, conn net.Conn) bool
+		// OnConnect is called after the client has passed the authentication.
+		// The client will be rejected with a CONNACK if it returns an error.
+		OnConnect(ctx context.Context, client Client, connect *packet.Connect) error
+		// OnConnected is called after the CONNACK has been sent.
+		OnConnected(ctx context.Context, client Client)
+		// OnSessionCreated is called when a new session is created.
+		OnSessionCreated(ctx context.Context, client Client)
+		// OnSessionResumed is called when the previous session is resumed.
+		OnSessionResumed(ctx context.Context, client Client)
+		// OnSessionTerminated is called when the session is removed.
+		OnSessionTerminated(ctx context.Context, clientId string, reason SessionTerminatedReason)
+		// OnSubscribe is called for each topic filter in the SUBSCRIBE packet, the topic can be modified, e.g. to downgrade the QoS.
+		// The subscription will be rejected with a failure code in the SUBACK if it returns an error.
+		OnSubscribe(ctx context.Context, client Client, topic *packet.Topic) error
+		// OnSubscribed is called after the subscription has been added.
+		OnSubscribed(ctx context.Context, client Client, subscription *sub.Subscription)
+		// OnUnsubscribe is called for each topic filter in the UNSUBSCRIBE packet.
+		// The subscription will not be removed if it returns an error.
+		OnUnsubscribe(ctx context.Context, client Client, topicFilter string) error
+		// OnMsgArrived is called when a PUBLISH packet is received, the message can be modified.
+		// The message will be dropped if it returns an error.
+		OnMsgArrived(ctx context.Context, client Client, msg *message.Message) error
+		// OnDelivered is called after the message has been sent to the client.
+		OnDelivered(ctx context.Context, client Client, msg *message.Message)
+		// OnMsgDropped is called when a message is dropped before it is sent to the client.
+		OnMsgDropped(ctx context.Context, clientId string, msg *message.Message, err error)
+		// OnClosed is called after the connection has been closed.
+		OnClosed(ctx context.Context, client Client, err error)
+		// OnStop is called when the server is stopping.
+		OnStop(ctx context.Context)
+	}
+
+	// HookBase implements Hook with no-op methods.
+	HookBase struct{}
+
+	// hooks is the chain of the registered Hook.
+	hooks []Hook
+)
+
+// WithHook registers the hooks, they are called in the order they are registered.
+func WithHook(hook ...Hook) Option {
+	return func(opts *Options) {
+		opts.hooks = append(opts.hooks, hook...)
+	}
+}
+
+// hookErrorCode returns the reason code of the error returned by a hook.
+func hookErrorCode(err error) code.Code {
+	var e *xerror.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return code.UnspecifiedError
+}
+
+func (HookBase) OnAccept(context.Context, net.Conn) bool                              { return true }
+func (HookBase) OnConnect(context.Context, Client, *packet.Connect) error             { return nil }
+func (HookBase) OnConnected(context.Context, Client)                                  {}
+func (HookBase) OnSessionCreated(context.Context, Client)                             {}
+func (HookBase) OnSessionResumed(context.Context, Client)                             {}
+func (HookBase) OnSessionTerminated(context.Context, string, SessionTerminatedReason) {}
+func (HookBase) OnSubscribe(context.Context, Client, *packet.Topic) error             { return nil }
+func (HookBase) OnSubscribed(context.Context, Client, *sub.Subscription)              {}
+func (HookBase) OnUnsubscribe(context.Context, Client, string) error                  { return nil }
+func (HookBase) OnMsgArrived(context.Context, Client, *message.Message) error         { return nil }
+func (HookBase) OnDelivered(context.Context, Client, *message.Message)                {}
+func (HookBase) OnMsgDropped(context.Context, string, *message.Message, error)        {}
+func (HookBase) OnClosed(context.Context, Client, error)                              {}
+func (HookBase) OnStop(context.Context)                                               {}
+
+func (hs hooks) onAccept(ctx context.Context, conn net.Conn) bool {
+	for _, h := range hs {
+		if !h.OnAccept(ctx, conn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hs hooks) onConnect(ctx context.Context, client Client, connect *packet.Connect) error {
+	for _, h := range hs {
+		if err := h.OnConnect(ctx, client, connect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hooks) onConnected(ctx context.Context, client Client) {
+	for _, h := range hs {
+		h.OnConnected(ctx, client)
+	}
+}
+
+func (hs hooks) onSessionCreated(ctx context.Context, client Client) {
+	for _, h := range hs {
+		h.OnSessionCreated(ctx, client)
+	}
+}
+
+func (hs hooks) onSessionResumed(ctx context.Context, client Client) {
+	for _, h := range hs {
+		h.OnSessionResumed(ctx, client)
+	}
+}
+
+func (hs hooks) onSessionTerminated(ctx context.Context, clientId string, reason SessionTerminatedReason) {
+	for _, h := range hs {
+		h.OnSessionTerminated(ctx, clientId, reason)
+	}
+}
+
+func (hs hooks) onSubscribe(ctx context.Context, client Client, topic *packet.Topic) error {
+	for _, h := range hs {
+		if err := h.OnSubscribe(ctx, client, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hooks) onSubscribed(ctx context.Context, client Client, subscription *sub.Subscription) {
+	for _, h := range hs {
+		h.OnSubscribed(ctx, client, subscription)
+	}
+}
+
+func (hs hooks) onUnsubscribe(ctx context.Context, client Client, topicFilter string) error {
+	for _, h := range hs {
+		if err := h.OnUnsubscribe(ctx, client, topicFilter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hooks) onMsgArrived(ctx context.Context, client Client, msg *message.Message) error {
+	for _, h := range hs {
+		if err := h.OnMsgArrived(ctx, client, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hooks) onDelivered(ctx context.Context, client Client, msg *message.Message) {
+	for _, h := range hs {
+		h.OnDelivered(ctx, client, msg)
+	}
+}
+
+func (hs hooks) onMsgDropped(ctx context.Context, clientId string, msg *message.Message, err error) {
+	for _, h := range hs {
+		h.OnMsgDropped(ctx, clientId, msg, err)
+	}
+}
+
+func (hs hooks) onClosed(ctx context.Context, client Client, err error) {
+	for _, h := range hs {
+		h.OnClosed(ctx, client, err)
+	}
+}
+
+func (hs hooks) onStop(ctx context.Context) {
+	for _, h := range hs {
+		h.OnStop(ctx)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordHook struct {
+	HookBase
+	mu     sync.Mutex
+	called []string
+	closed chan struct{}
+}
+
+func newRecordHook() *recordHook {
+	return &recordHook{closed: make(chan struct{}, 8)}
+}
+
+func (h *recordHook) record(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.called = append(h.called, name)
+}
+
+func (h *recordHook) calls() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.called...)
+}
+
+func (h *recordHook) OnConnect(_ context.Context, client Client, _ *packet.Connect) error {
+	h.record("OnConnect")
+	if client.ClientOption().ClientId == "rejected" {
+		return xerror.NewError(code.Banned)
+	}
+	return nil
+}
+
+func (h *recordHook) OnConnected(context.Context, Client) {
+	h.record("OnConnected")
+}
+
+func (h *recordHook) OnSessionCreated(context.Context, Client) {
+	h.record("OnSessionCreated")
+}
+
+func (h *recordHook) OnSessionTerminated(context.Context, string, SessionTerminatedReason) {
+	h.record("OnSessionTerminated")
+}
+
+func (h *recordHook) OnSubscribe(_ context.Context, _ Client, topic *packet.Topic) error {
+	if topic.Name == "forbidden" {
+		return xerror.NewError(code.TopicFilterInvalid)
+	}
+	// 降级为 QoS0
+	topic.QoS = packet.QoS0
+	return nil
+}
+
+func (h *recordHook) OnSubscribed(context.Context, Client, *sub.Subscription) {
+	h.record("OnSubscribed")
+}
+
+func (h *recordHook) OnMsgArrived(_ context.Context, _ Client, msg *message.Message) error {
+	if string(msg.Payload) == "drop" {
+		return xerror.NewError(code.PayloadFormatInvalid)
+	}
+	msg.Payload = append(msg.Payload, []byte(" enriched")...)
+	return nil
+}
+
+func (h *recordHook) OnClosed(context.Context, Client, error) {
+	h.record("OnClosed")
+	h.closed <- struct{}{}
+}
+
+func TestServer_hooks(t *testing.T) {
+	a := assert.New(t)
+	h := newRecordHook()
+	s := newTestServer(t, WithHook(h))
+
+	rejected := dial(t, s)
+	a.Equal(code.Banned, rejected.connectV5("rejected", true, nil).Code)
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("hook", true, nil).Code)
+	suback := c.subscribe(1,
+		&packet.Topic{Name: "a/b", SubOptions: packet.SubOptions{QoS: packet.QoS1}},
+		&packet.Topic{Name: "forbidden", SubOptions: packet.SubOptions{QoS: packet.QoS1}},
+	)
+	a.Equal([]code.Code{code.GrantedQoS0, code.TopicFilterInvalid}, suback.Payload)
+
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS1, PacketId: 1, TopicName: []byte("a/b"), Payload: []byte("drop")})
+	puback, ok := c.read().(*packet.Puback)
+	a.True(ok)
+	a.Equal(code.PayloadFormatInvalid, puback.Code)
+
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS0, TopicName: []byte("a/b"), Payload: []byte("hello")})
+	publish, ok := c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("hello enriched"), publish.Payload)
+
+	c.write(&packet.Unsubscribe{Version: packet.Version5, PacketId: 2, Topics: []string{"a/b"}})
+	unsuback, ok := c.read().(*packet.Unsuback)
+	a.True(ok)
+	a.Equal([]code.Code{code.Success}, unsuback.Payload)
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS1, PacketId: 3, TopicName: []byte("a/b"), Payload: []byte("nobody")})
+	_, ok = c.read().(*packet.Puback)
+	a.True(ok)
+
+	c.write(&packet.Disconnect{Version: packet.Version5})
+	select {
+	case <-h.closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnClosed is not called")
+	}
+	a.Equal([]string{
+		"OnConnect",
+		"OnConnect", "OnSessionCreated", "OnConnected",
+		"OnSubscribed",
+		"OnSessionTerminated", "OnClosed",
+	}, h.calls())
+}
+
+func TestHookErrorCode(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(code.Banned, hookErrorCode(xerror.NewError(code.Banned)))
+	a.Equal(code.UnspecifiedError, hookErrorCode(context.Canceled))
+}
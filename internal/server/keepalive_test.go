@@ -0,0 +1,117 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	c := &config.Mqtt{MaxKeepAlive: 300}
+	assert.Equal(t, uint16(60), keepAlive(60, c))
+	assert.Equal(t, uint16(300), keepAlive(600, c))
+	assert.Equal(t, uint16(0), keepAlive(0, c))
+	c.DefaultKeepAlive = 30
+	assert.Equal(t, uint16(30), keepAlive(0, c))
+	c.MaxKeepAlive = 0
+	assert.Equal(t, uint16(600), keepAlive(600, c))
+}
+
+func TestServer_keepAliveTimeout(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.MaxKeepAlive = 1
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	sub.subscribe(1, &packet.Topic{Name: "will/#"})
+	// 保持订阅者连接
+	ticker := time.NewTicker(500 * time.Millisecond)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		ticker.Stop()
+		close(done)
+	})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if sub.w.WritePacketAndFlush(&packet.Pingreq{}) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	c := dial(t, s)
+	c.isV5 = true
+	connack := c.connectWithWill("idle", true, nil, nil)
+	a.Equal(code.Success, connack.Code)
+	a.Equal(uint16(1), *connack.Properties.ServerKeepAlive)
+
+	// 1.5 倍 Keep Alive 时间内没有报文, 断开连接并发布遗嘱
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.KeepAliveTimeout, disconnect.Code)
+	for {
+		p := sub.read()
+		if publish, ok := p.(*packet.Publish); ok {
+			a.Equal("will/idle", string(publish.TopicName))
+			break
+		}
+	}
+}
+
+func TestServer_defaultKeepAlive(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.DefaultKeepAlive = 1
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	c := dial(t, s)
+	c.isV5 = true
+	c.r.SetVersion(packet.Version5)
+	c.write(&packet.Connect{
+		Version:       packet.Version5,
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(packet.Version5),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: true},
+		ClientId:      []byte("zero"),
+	})
+	connack, ok := c.read().(*packet.Connack)
+	a.True(ok)
+	a.Equal(uint16(1), *connack.Properties.ServerKeepAlive)
+	start := time.Now()
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.KeepAliveTimeout, disconnect.Code)
+	a.GreaterOrEqual(time.Since(start), time.Second)
+
+	// 不超过 Keep Alive 不返回 Server Keep Alive
+	c = dial(t, s)
+	connack = c.connectV5("normal", true, nil)
+	a.Nil(connack.Properties.ServerKeepAlive)
+}
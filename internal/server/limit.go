@@ -61,8 +61,10 @@ func (p *packetIdLimiter) unlock() {
 
 // markUsedLocked marks the given id as used.
 func (p *packetIdLimiter) markUsedLocked(packetId packet.Id) {
-	p.used++
-	p.lockedPacketIdMap.Set(packetId, 1)
+	if p.lockedPacketIdMap.Get(packetId) == 0 {
+		p.used++
+		p.lockedPacketIdMap.Set(packetId, 1)
+	}
 }
 
 func (p *packetIdLimiter) unlockAndSignal() {
@@ -60,3 +60,15 @@ func Test_packetIDLimiterMax(t *testing.T) {
 	a.Equal([]packet.Id{packet.MaxPacketID}, p.pollPacketIds(3))
 
 }
+
+func Test_packetIDLimiterMarkUsed(t *testing.T) {
+	a := assert.New(t)
+	p := newPacketIDLimiter(2)
+	p.lock()
+	p.markUsedLocked(1)
+	p.markUsedLocked(1)
+	p.unlock()
+	a.Equal([]packet.Id{2}, p.pollPacketIds(2))
+	p.release(1)
+	a.Equal([]packet.Id{3}, p.pollPacketIds(2))
+}
@@ -0,0 +1,215 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/acl"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/goroutine"
+	"github.com/yunqi/lighthouse/internal/proxyproto"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"github.com/yunqi/lighthouse/internal/xtls"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// listener is a named listener which runs its own accept loop.
+type listener struct {
+	server        *server
+	config        *config.Listener
+	authenticator auth.Authenticator // the authenticator of the listener, default to the one of the server
+	acl           *acl.ACL           // the ACL of the listener, default to the one of the server
+	ln            net.Listener
+	httpServer    *http.Server        // only for ws and wss
+	upgrader      *websocket.Upgrader // only for ws and wss
+	connections   int64               // the number of the current connections
+	log           *zap.Logger
+}
+
+// WithListener adds the named listeners, a tcp listener on ":1883" is used if no listener is added.
+func WithListener(listeners ...*config.Listener) Option {
+	return func(opts *Options) {
+		opts.listeners = append(opts.listeners, listeners...)
+	}
+}
+
+// newListener starts listening on the address of the listener.
+func newListener(s *server, c *config.Listener) (*listener, error) {
+	l := &listener{
+		server:        s,
+		config:        c,
+		authenticator: s.authenticator,
+		acl:           s.acl,
+		log:           xlog.LoggerModule("listener").With(zap.String("listener", c.Name)),
+	}
+	if c.Auth != nil {
+		authenticator, err := auth.New(c.Auth)
+		if err != nil {
+			return nil, err
+		}
+		l.authenticator = authenticator
+	}
+	if c.ACL != nil {
+		a, err := acl.New(c.ACL)
+		if err != nil {
+			return nil, err
+		}
+		l.acl = a
+	}
+
+	network := "tcp"
+	switch c.Protocol {
+	case config.ProtocolTCP, config.ProtocolWS:
+	case config.ProtocolUnix:
+		network = "unix"
+	case config.ProtocolTLS, config.ProtocolWSS:
+		if c.TLS == nil {
+			return nil, fmt.Errorf("listener %q: tls is required for %s", c.Name, c.Protocol)
+		}
+	default:
+		return nil, fmt.Errorf("listener %q: invalid protocol %q", c.Name, c.Protocol)
+	}
+
+	var tlsConfig *tls.Config
+	if l.isTLS() {
+		var err error
+		if tlsConfig, err = xtls.NewConfig(c.TLS); err != nil {
+			return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+		}
+	}
+	ln, err := net.Listen(network, c.Address)
+	if err != nil {
+		return nil, err
+	}
+	// PROXY 头部在 TLS 握手之前
+	if c.ProxyProtocol != nil {
+		pln, err := proxyproto.NewListener(ln, c.ProxyProtocol.TrustedCIDRs, c.ProxyProtocol.HeaderTimeout)
+		if err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+		}
+		ln = pln
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	l.ln = ln
+
+	if c.Protocol == config.ProtocolWS || c.Protocol == config.ProtocolWSS {
+		path := c.Path
+		if path == "" {
+			path = "/mqtt"
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(path, l.serveWebsocket)
+		l.httpServer = &http.Server{Handler: mux, ConnContext: connContext}
+		l.upgrader = newUpgrader(c.AllowedOrigins)
+	}
+	return l, nil
+}
+
+// isTLS returns whether the connections of the listener are over TLS.
+func (l *listener) isTLS() bool {
+	return l.config.Protocol == config.ProtocolTLS || l.config.Protocol == config.ProtocolWSS
+}
+
+// tlsConfig returns the TLS configuration of the listener, nil if TLS is disabled.
+func (l *listener) tlsConfig() *config.TLS {
+	if l.isTLS() {
+		return l.config.TLS
+	}
+	return nil
+}
+
+// serve runs the accept loop until the listener is closed.
+func (l *listener) serve() {
+	if l.httpServer != nil {
+		err := l.httpServer.Serve(l.ln)
+		if err != nil && err != http.ErrServerClosed {
+			l.log.Error("websocket server", zap.Error(err))
+		}
+		return
+	}
+
+	defer func() {
+		err := l.ln.Close()
+		if err != nil {
+			l.log.Debug("listener close", zap.Error(err))
+		}
+	}()
+	var tempDelay time.Duration
+	for {
+		accept, err := l.ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return
+		}
+		tempDelay = 0
+		l.serveConn(accept)
+	}
+}
+
+// serveConn creates a client for the accepted connection and serves it.
+// The connection is closed if the listener has reached the maximum connections.
+func (l *listener) serveConn(conn net.Conn) {
+	if max := int64(l.config.MaxConnections); atomic.AddInt64(&l.connections, 1) > max && max > 0 {
+		atomic.AddInt64(&l.connections, -1)
+		l.log.Warn("too many connections", zap.String("IP", conn.RemoteAddr().String()), zap.Int64("max", max))
+		_ = conn.Close()
+		return
+	}
+	// PROXY 头部和 TLS 握手可能阻塞, 不能在 accept 循环中进行
+	goroutine.Go(func() {
+		defer atomic.AddInt64(&l.connections, -1)
+		if !l.server.hooks.onAccept(context.Background(), conn) {
+			_ = conn.Close()
+			return
+		}
+		// 创建一个客户端连接
+		c := newClient(l.server, conn)
+		c.listener = l
+		// 监听该连接
+		c.listen()
+	})
+}
+
+// close closes the listener, the accepted connections are not closed.
+func (l *listener) close() error {
+	if l.httpServer != nil {
+		return l.httpServer.Close()
+	}
+	return l.ln.Close()
+}
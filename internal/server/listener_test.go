@@ -0,0 +1,139 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/proxyproto"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_listeners(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t, WithListener(&config.Listener{
+		Name:     "unix",
+		Protocol: config.ProtocolUnix,
+		Address:  filepath.Join(t.TempDir(), "mqtt.sock"),
+		ACL:      &config.ACL{NoMatch: "deny"},
+	}))
+
+	// 每个监听器使用各自的 ACL
+	c := dial(t, s)
+	a.Equal(code.V3Accepted, c.connect("tcp", true).Code)
+	a.Equal([]code.Code{code.GrantedQoS0}, c.subscribe(1, &packet.Topic{Name: "a"}).Payload)
+
+	c = dialListener(t, s, "unix")
+	a.Equal(code.V3Accepted, c.connect("unix", true).Code)
+	a.Equal([]code.Code{packet.SubscribeFailure}, c.subscribe(1, &packet.Topic{Name: "a"}).Payload)
+}
+
+func TestServer_maxConnections(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t, WithListener(&config.Listener{Name: "limited", Protocol: config.ProtocolTCP, Address: "127.0.0.1:0", MaxConnections: 1}))
+
+	c := dialListener(t, s, "limited")
+	a.Equal(code.V3Accepted, c.connect("A", true).Code)
+
+	// 超过最大连接数的连接被关闭
+	rejected := dialListener(t, s, "limited")
+	_ = rejected.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err := rejected.r.Read()
+	a.Error(err)
+
+	_ = c.conn.Close()
+	l := s.listeners[len(s.listeners)-1]
+	a.Eventually(func() bool {
+		return atomic.LoadInt64(&l.connections) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	c = dialListener(t, s, "limited")
+	a.Equal(code.V3Accepted, c.connect("B", true).Code)
+}
+
+type addrAuthenticator chan net.Addr
+
+func (a addrAuthenticator) Authenticate(_ context.Context, _ *packet.Connect, remoteAddr net.Addr) error {
+	a <- remoteAddr
+	return nil
+}
+
+func TestServer_proxyProtocol(t *testing.T) {
+	a := assert.New(t)
+	addrs := make(addrAuthenticator, 1)
+	s := newTestServer(t, WithAuthenticator(addrs), WithListener(&config.Listener{
+		Name:          "proxy",
+		Protocol:      config.ProtocolTCP,
+		Address:       "127.0.0.1:0",
+		ProxyProtocol: &config.ProxyProtocol{TrustedCIDRs: []string{"127.0.0.1/32"}},
+		ACL: &config.ACL{Rules: []config.ACLRule{
+			{Permission: "deny", IPAddr: "10.0.0.0/8", Topics: []string{"#"}},
+		}},
+	}))
+
+	c := dialListener(t, s, "proxy")
+	_, err := c.conn.Write([]byte("PROXY TCP4 10.0.0.1 10.0.0.2 56324 1883\r\n"))
+	a.NoError(err)
+	a.Equal(code.V3Accepted, c.connect("proxy", true).Code)
+	a.Equal("10.0.0.1:56324", (<-addrs).String())
+	// ACL 使用 PROXY 头部中的客户端地址
+	a.Equal([]code.Code{packet.SubscribeFailure}, c.subscribe(1, &packet.Topic{Name: "a"}).Payload)
+}
+
+// serverNameAuthenticator sends the TLS SNI of the clients to the channel.
+type serverNameAuthenticator chan string
+
+func (a serverNameAuthenticator) Authenticate(ctx context.Context, _ *packet.Connect, _ net.Addr) error {
+	a <- auth.ServerNameFromContext(ctx)
+	return nil
+}
+
+func TestServer_proxyProtocolServerName(t *testing.T) {
+	a := assert.New(t)
+	names := make(serverNameAuthenticator, 1)
+	s := newTestServer(t, WithAuthenticator(names), WithListener(&config.Listener{
+		Name:          "proxy",
+		Protocol:      config.ProtocolTCP,
+		Address:       "127.0.0.1:0",
+		ProxyProtocol: &config.ProxyProtocol{TrustedCIDRs: []string{"127.0.0.1/32"}},
+	}))
+
+	authority := []byte("mqtt.example.com")
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11")
+	header = append(header, 0, byte(12+3+len(authority)))
+	header = append(header, 10, 0, 0, 1, 10, 0, 0, 2, 0xdc, 0x04, 0x07, 0x5b)
+	header = append(header, proxyproto.TypeAuthority, 0, byte(len(authority)))
+	header = append(header, authority...)
+
+	c := dialListener(t, s, "proxy")
+	_, err := c.conn.Write(header)
+	a.NoError(err)
+	a.Equal(code.V3Accepted, c.connect("proxy", true).Code)
+	a.Equal("mqtt.example.com", <-names)
+
+	s.mu.RLock()
+	cli := s.clients["proxy"]
+	s.mu.RUnlock()
+	a.Equal("mqtt.example.com", cli.ClientOption().ServerName)
+}
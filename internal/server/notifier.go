@@ -0,0 +1,73 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/xlog"
+	"go.uber.org/zap"
+	"sync/atomic"
+)
+
+var _ queue.Notifier = (*queueNotifier)(nil)
+
+// queueNotifier receives the notifications of the queue of one client.
+type queueNotifier struct {
+	clientId string
+	hooks    hooks
+	log      *xlog.Log
+	queued   int64 // the number of messages in the queue, including the inflight messages
+	inflight int64 // the number of inflight messages
+}
+
+func newQueueNotifier(clientId string, hooks hooks) *queueNotifier {
+	return &queueNotifier{
+		clientId: clientId,
+		hooks:    hooks,
+		log:      xlog.LoggerModule("queue"),
+	}
+}
+
+func (n *queueNotifier) NotifyDropped(elem *queue.Element, err error) {
+	n.log.Warn("message dropped", zap.String("clientId", n.clientId), zap.Uint16("packetId", elem.Id()), zap.Error(err))
+	if p, ok := elem.Message.(*queue.Publish); ok {
+		n.hooks.onMsgDropped(context.Background(), n.clientId, p.Message, err)
+	}
+}
+
+func (n *queueNotifier) NotifyInflightAdded(delta int) {
+	atomic.AddInt64(&n.inflight, int64(delta))
+}
+
+func (n *queueNotifier) NotifyMsgQueueAdded(delta int) {
+	atomic.AddInt64(&n.queued, int64(delta))
+}
+
+// queueLen returns the number of messages in the queue which are added after the queue created.
+func (n *queueNotifier) queueLen() int64 {
+	return atomic.LoadInt64(&n.queued)
+}
+
+func (n *queueNotifier) stats() ClientStats {
+	queued, inflight := atomic.LoadInt64(&n.queued), atomic.LoadInt64(&n.inflight)
+	// 队列中的消息包含飞行窗口中的消息
+	if queued -= inflight; queued < 0 {
+		queued = 0
+	}
+	return ClientStats{InflightMessages: inflight, QueuedMessages: queued}
+}
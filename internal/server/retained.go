@@ -0,0 +1,80 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xerror"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// retainMessage stores or removes the retained message of the topic.
+// A retained message with zero-length payload removes the existing retained message of the topic [MQTT-3.3.1-6].
+func (c *client) retainMessage(msg *message.Message) *xerror.Error {
+	if !c.server.config.RetainAvailable {
+		// [MQTT-3.3.1-13]
+		if packet.IsVersion5(c.version) {
+			return xerror.NewError(code.RetainNotSupported)
+		}
+		return nil
+	}
+	if len(msg.Payload) == 0 {
+		c.server.retainedStore.Remove(msg.Topic)
+		return nil
+	}
+	c.server.retainedStore.AddOrReplace(msg.Copy())
+	return nil
+}
+
+// deliverRetained delivers the matched retained messages of the new subscriptions according to the Retain Handling option.
+func (c *client) deliverRetained(ctx context.Context, result subscription.SubscribeResult) {
+	if !c.server.config.RetainAvailable {
+		return
+	}
+	for _, r := range result {
+		s := r.Subscription
+		// 共享订阅不发送保留消息 [MQTT-3.8.4-4]
+		if s.ShareName != "" || strings.HasPrefix(s.TopicFilter, "$share/") {
+			continue
+		}
+		switch s.RetainHandling {
+		case packet.RetainHandlingSendAtSubscribe:
+		case packet.RetainHandlingSendAtSubscribeIfNotExist:
+			if r.AlreadyExisted {
+				continue
+			}
+		default:
+			continue
+		}
+		for _, m := range c.server.retainedStore.GetMatchedMessages(s.TopicFilter) {
+			msgs := c.server.newDeliverMessages("", c.clientId, m, []*sub.Subscription{s})
+			for _, msg := range msgs {
+				// 订阅时发送的保留消息 RETAIN 标志必须为 1 [MQTT-3.3.1-9]
+				msg.Retained = true
+			}
+			if err := c.server.enqueue(ctx, c.clientId, c.queueStore, msgs...); err != nil {
+				c.log.WithContext(ctx).Error("deliver retained message", zap.String("topic", m.Topic), zap.Error(err))
+			}
+		}
+	}
+}
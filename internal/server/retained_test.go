@@ -0,0 +1,89 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"testing"
+)
+
+func TestServer_retained(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	pub.write(&packet.Publish{QoS: packet.QoS0, Retain: true, TopicName: []byte("state/a"), Payload: []byte("a")})
+	pub.write(&packet.Publish{QoS: packet.QoS0, Retain: true, TopicName: []byte("state/b"), Payload: []byte("b")})
+	// 空负载删除保留消息
+	pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: 1, Retain: true, TopicName: []byte("state/b")})
+	_, ok := pub.read().(*packet.Puback)
+	a.True(ok)
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("sub", true, nil).Code)
+	suback := c.subscribe(1, &packet.Topic{Name: "state/#", SubOptions: packet.SubOptions{QoS: packet.QoS0}})
+	a.Equal([]code.Code{code.GrantedQoS0}, suback.Payload)
+	publish, ok := c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("state/a", string(publish.TopicName))
+	a.True(publish.Retain)
+
+	// Retain Handling 1: 订阅已存在时不发送
+	c.subscribe(2, &packet.Topic{Name: "state/#", SubOptions: packet.SubOptions{RetainHandling: packet.RetainHandlingSendAtSubscribeIfNotExist}})
+	// Retain Handling 2: 不发送
+	c.subscribe(3, &packet.Topic{Name: "state/+", SubOptions: packet.SubOptions{RetainHandling: packet.RetainHandlingDoNotSend}})
+	// Retain Handling 0: 总是发送
+	c.subscribe(4, &packet.Topic{Name: "state/a", SubOptions: packet.SubOptions{RetainHandling: packet.RetainHandlingSendAtSubscribe}})
+	publish, ok = c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("state/a", string(publish.TopicName))
+	a.True(publish.Retain)
+
+	// 未设置 Retain As Published 时，转发的消息 RETAIN 标志为 0
+	pub.write(&packet.Publish{QoS: packet.QoS0, Retain: true, TopicName: []byte("state/c"), Payload: []byte("c")})
+	publish, ok = c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("state/c", string(publish.TopicName))
+	a.False(publish.Retain)
+}
+
+func TestServer_retainNotAvailable(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.RetainAvailable = false
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	c := dial(t, s)
+	connack := c.connectV5("v5", true, nil)
+	a.Equal(code.Success, connack.Code)
+	a.Equal(byte(0), *connack.Properties.RetainAvailable)
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS0, Retain: true, TopicName: []byte("a"), Payload: []byte("a")})
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.RetainNotSupported, disconnect.Code)
+
+	v3 := dial(t, s)
+	a.Equal(code.Success, v3.connect("v3", true).Code)
+	v3.write(&packet.Publish{QoS: packet.QoS1, PacketId: 1, Retain: true, TopicName: []byte("a"), Payload: []byte("a")})
+	_, ok = v3.read().(*packet.Puback)
+	a.True(ok)
+	a.Empty(s.retainedStore.GetMatchedMessages("#"))
+}
@@ -18,21 +18,49 @@ package server
 
 import (
 	"context"
-	"github.com/gorilla/websocket"
 	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/acl"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/code"
 	"github.com/yunqi/lighthouse/internal/goroutine"
 	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"github.com/yunqi/lighthouse/internal/persistence/queue"
+	"github.com/yunqi/lighthouse/internal/persistence/retained"
 	"github.com/yunqi/lighthouse/internal/persistence/session"
 	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"github.com/yunqi/lighthouse/internal/persistence/unack"
+	sess "github.com/yunqi/lighthouse/internal/session"
+	"github.com/yunqi/lighthouse/internal/shared"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"github.com/yunqi/lighthouse/internal/xerror"
 	"github.com/yunqi/lighthouse/internal/xlog"
 	"github.com/yunqi/lighthouse/internal/xtrace"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
+// defaultShutdownTimeout is the default maximum time for Run to wait for the server to stop gracefully.
+const defaultShutdownTimeout = 30 * time.Second
+
+var (
+	_ Server = (*server)(nil)
+
+	// ErrServerShuttingDown is the reason why the connections are closed when the server is stopping.
+	ErrServerShuttingDown = xerror.NewError(code.ServerShuttingDown)
+	// ErrKeepAliveTimeout is the reason why the connection is closed when no packet is received within 1.5 times the keep alive time.
+	ErrKeepAliveTimeout = xerror.NewError(code.KeepAliveTimeout)
+	// ErrReceiveMaxExceeded is the reason why the connection is closed when the client sends more QoS 1 and QoS 2
+	// publications than the Receive Maximum without waiting for the acknowledgements.
+	ErrReceiveMaxExceeded = xerror.NewError(code.RecvMaxExceeded)
+)
+
 type (
 	Server interface {
 		Stop(ctx context.Context) error
@@ -41,26 +69,49 @@ type (
 	Option func(server *Options)
 
 	Options struct {
-		tcpListen       string
-		websocketListen string
+		listeners       []*config.Listener
 		persistence     *config.Persistence
+		mqtt            *config.Mqtt
+		enhancedAuths   []EnhancedAuthenticator
+		authenticator   auth.Authenticator
+		acl             *acl.ACL
+		hooks           []Hook
+		shutdownTimeout time.Duration
 	}
 	server struct {
-		tcpListen         string
-		websocketListen   string
-		tcpListener       net.Listener //tcp listeners
-		websocketListener *websocket.Conn
+		listeners         []*listener
 		sessionStore      session.Store
 		subscriptionStore subscription.Store
+		retainedStore     retained.Store
+		config            *config.Mqtt
+		queueConfig       *config.StoreType
+		newQueueStore     queue.NewStore
+		unackConfig       *config.StoreType
+		newUnackStore     unack.NewStore
+		mu                sync.RWMutex
+		queueStore        map[string]queue.Queue           // [clientId]
+		queueNotifiers    map[string]*queueNotifier        // [clientId] the notifiers of the queue stores
+		unackStore        map[string]unack.Store           // [clientId]
+		clients           map[string]*client               // [clientId] connected clients
+		enhancedAuths     map[string]EnhancedAuthenticator // [auth method]
+		authenticator     auth.Authenticator
+		acl               *acl.ACL
+		hooks             hooks
+		sharedStrategy    shared.Strategy
+		willMu            sync.Mutex
+		willMessages      map[string]*willMessage // [clientId]
+		exit              chan struct{}           // closed when the server is stopping
+		stopOnce          sync.Once
+		stopErr           error
+		shutdownTimeout   time.Duration
 		log               *xlog.Log
 		tracer            trace.Tracer
 	}
 )
 
+// WithTcpListen adds a tcp listener named "tcp".
 func WithTcpListen(tcpListen string) Option {
-	return func(opts *Options) {
-		opts.tcpListen = tcpListen
-	}
+	return WithListener(&config.Listener{Name: "tcp", Protocol: config.ProtocolTCP, Address: tcpListen})
 }
 func WithPersistence(persistence *config.Persistence) Option {
 	return func(opts *Options) {
@@ -68,9 +119,31 @@ func WithPersistence(persistence *config.Persistence) Option {
 	}
 }
 
-func WithWebsocketListen(websocketListen string) Option {
+// WithShutdownTimeout sets the maximum time for Run to wait for the server to stop gracefully, default to 30s.
+func WithShutdownTimeout(timeout time.Duration) Option {
 	return func(opts *Options) {
-		opts.websocketListen = websocketListen
+		opts.shutdownTimeout = timeout
+	}
+}
+
+// WithMqtt sets the MQTT protocol configuration.
+func WithMqtt(mqtt *config.Mqtt) Option {
+	return func(opts *Options) {
+		opts.mqtt = mqtt
+	}
+}
+
+// WithAuthenticator sets the authenticator of the CONNECT packet, nil means all clients are allowed to connect.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(opts *Options) {
+		opts.authenticator = authenticator
+	}
+}
+
+// WithACL sets the topic-level authorization, nil means all topics are allowed.
+func WithACL(acl *acl.ACL) Option {
+	return func(opts *Options) {
+		opts.acl = acl
 	}
 }
 
@@ -86,55 +159,57 @@ func loadServerOptions(opts ...Option) *Options {
 	for _, opt := range opts {
 		opt(options)
 	}
-	if options.tcpListen == "" {
-		options.tcpListen = ":1883"
+	if options.shutdownTimeout <= 0 {
+		options.shutdownTimeout = defaultShutdownTimeout
+	}
+	if len(options.listeners) == 0 {
+		options.listeners = []*config.Listener{{Name: "tcp", Protocol: config.ProtocolTCP, Address: ":1883"}}
+	}
+	if options.mqtt == nil {
+		mqtt := config.DefaultMqtt
+		options.mqtt = &mqtt
 	}
 	return options
 }
 
-func (s *server) ServeTCP() {
-	s.tracer = otel.GetTracerProvider().Tracer(xtrace.Name)
-
-	defer func() {
-		err := s.tcpListener.Close()
-		if err != nil {
-			s.log.Error("tcpListener close", zap.Error(err))
-		}
-	}()
-	var tempDelay time.Duration
-
-	for {
-		accept, err := s.tcpListener.Accept()
-		if err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Temporary() {
-				if tempDelay == 0 {
-					tempDelay = 5 * time.Millisecond
-				} else {
-					tempDelay *= 2
-				}
-				if max := 1 * time.Second; tempDelay > max {
-					tempDelay = max
-				}
-				time.Sleep(tempDelay)
-				continue
-			}
-			return
-		}
-		// 创建一个客户端连接
-
-		c := newClient(s, accept)
-		// 监听该连接
-		goroutine.Go(func() {
-			c.listen()
-		})
-
+// Serve runs the accept loops of all the listeners, it blocks until all the listeners are closed.
+func (s *server) Serve() {
+	goroutine.Go(s.sessionExpiryCheck)
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		l := l
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.serve()
+		}()
 	}
+	wg.Wait()
 }
 
 func (s *server) init(opts *Options) {
-	s.tcpListen = opts.tcpListen
-	s.websocketListen = opts.websocketListen
+	s.config = opts.mqtt
+	s.queueStore = make(map[string]queue.Queue)
+	s.queueNotifiers = make(map[string]*queueNotifier)
+	s.unackStore = make(map[string]unack.Store)
+	s.clients = make(map[string]*client)
+	s.exit = make(chan struct{})
+	s.shutdownTimeout = opts.shutdownTimeout
+	s.willMessages = make(map[string]*willMessage)
 	s.log = xlog.LoggerModule("server")
+	s.tracer = otel.GetTracerProvider().Tracer(xtrace.Name)
+	s.authenticator = opts.authenticator
+	s.acl = opts.acl
+	s.hooks = opts.hooks
+	s.enhancedAuths = make(map[string]EnhancedAuthenticator, len(opts.enhancedAuths))
+	for _, auth := range opts.enhancedAuths {
+		s.enhancedAuths[auth.Method()] = auth
+	}
+	newStrategy, ok := shared.GetStrategy(s.config.SharedSubStrategy)
+	if !ok {
+		s.log.Panic("invalid shared subscription strategy", zap.String("strategy", s.config.SharedSubStrategy))
+	}
+	s.sharedStrategy = newStrategy()
 
 	// session store
 	sessionStore, ok := persistence.GetSessionStore(opts.persistence.Session.Type)
@@ -162,11 +237,334 @@ func (s *server) init(opts *Options) {
 		s.log.Info("subscriptionStore store", zap.String("type", opts.persistence.Session.Type))
 	}
 
-	ln, err := net.Listen("tcp", s.tcpListen)
+	// retained store
+	retainedStoreFunc, ok := persistence.GetRetainedStore(opts.persistence.Retained.Type)
+	if !ok {
+		s.log.Panic("invalid retained store")
+	}
+	if retainedStore, err := retainedStoreFunc(&opts.persistence.Retained); err != nil {
+		s.log.Panic("retained store", zap.Error(err))
+	} else {
+		s.retainedStore = retainedStore
+		s.log.Info("retained store", zap.String("type", opts.persistence.Retained.Type))
+	}
+
+	// queue store
+	queueStoreFunc, ok := persistence.GetQueueStore(opts.persistence.Queue.Type)
+	if !ok {
+		s.log.Panic("invalid queue store")
+	}
+	s.newQueueStore = queueStoreFunc
+	s.queueConfig = &opts.persistence.Queue
+	s.log.Info("queue store", zap.String("type", opts.persistence.Queue.Type))
+
+	// unack store
+	unackStoreFunc, ok := persistence.GetUnackStore(opts.persistence.Unack.Type)
+	if !ok {
+		s.log.Panic("invalid unack store")
+	}
+	s.newUnackStore = unackStoreFunc
+	s.unackConfig = &opts.persistence.Unack
+	s.log.Info("unack store", zap.String("type", opts.persistence.Unack.Type))
+
+	// 加载已持久化会话的订阅
+	var clientIds []string
+	err := s.sessionStore.Iterate(context.Background(), func(session *sess.Session) bool {
+		clientIds = append(clientIds, session.ClientId)
+		return true
+	})
+	if err != nil {
+		s.log.Panic("iterate sessions", zap.Error(err))
+	}
+	if err = s.subscriptionStore.Init(context.Background(), clientIds); err != nil {
+		s.log.Panic("init subscription store", zap.Error(err))
+	}
+
+	for _, c := range opts.listeners {
+		l, err := newListener(s, c)
+		if err != nil {
+			s.log.Panic("start listener error", zap.String("name", c.Name), zap.String("address", c.Address), zap.Error(err))
+		}
+		s.log.Info("start listener", zap.String("name", c.Name), zap.String("protocol", c.Protocol), zap.String("address", l.ln.Addr().String()))
+		s.listeners = append(s.listeners, l)
+	}
+}
+
+// getQueueStore returns the queue and its notifier of the given client, the queue will be created if it does not exist.
+func (s *server) getQueueStore(clientId string) (queue.Queue, *queueNotifier, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queueStore[clientId]; ok {
+		return q, s.queueNotifiers[clientId], nil
+	}
+	// notifier 与队列的生命周期相同，会话恢复后继续统计
+	n := newQueueNotifier(clientId, s.hooks)
+	q, err := s.newQueueStore(s.queueConfig, &queue.Options{
+		ClientId:        clientId,
+		MaxQueuedMsg:    s.config.MaxQueueMessages,
+		InflightExpiry:  s.config.InflightExpiry,
+		DefaultNotifier: n,
+	})
 	if err != nil {
-		s.log.Panic("start tcp error", zap.String("tcp", s.tcpListen), zap.Error(err))
+		return nil, nil, err
 	}
-	s.log.Info("start tcp", zap.String("TCP", s.tcpListen))
-	s.tcpListener = ln
+	s.queueStore[clientId] = q
+	s.queueNotifiers[clientId] = n
+	return q, n, nil
+}
 
+// loadQueueStore returns the queue of the client which has a session.
+// The queue is created if the session is in the session store but the queue is not loaded,
+// e.g. the offline client whose session is persisted before the server restarts.
+func (s *server) loadQueueStore(ctx context.Context, clientId string) (queue.Queue, bool) {
+	s.mu.RLock()
+	q, ok := s.queueStore[clientId]
+	s.mu.RUnlock()
+	if ok {
+		return q, true
+	}
+	sess, err := s.sessionStore.Get(ctx, clientId)
+	if err != nil {
+		s.log.Error("get session", zap.String("clientId", clientId), zap.Error(err))
+		return nil, false
+	}
+	if sess == nil || sess.ClientId == "" {
+		return nil, false
+	}
+	q, _, err = s.getQueueStore(clientId)
+	if err != nil {
+		s.log.Error("get queue store", zap.String("clientId", clientId), zap.Error(err))
+		return nil, false
+	}
+	return q, true
+}
+
+// isSlowLocked returns whether the client can not keep up with the messages, the queue of the client is full.
+// s.mu must be held.
+func (s *server) isSlowLocked(clientId string) bool {
+	max := s.config.MaxQueueMessages
+	n, ok := s.queueNotifiers[clientId]
+	return max > 0 && ok && n.queueLen() >= int64(max)
+}
+
+// Run runs the accept loops of all the listeners, and stops the server gracefully on SIGINT or SIGTERM.
+// It returns after the server is stopped.
+func (s *server) Run() error {
+	served := make(chan struct{})
+	goroutine.Go(func() {
+		defer close(served)
+		s.Serve()
+	})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case sig := <-signals:
+		s.log.Info("received signal", zap.String("signal", sig.String()))
+	case <-served:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	return s.Stop(ctx)
+}
+
+// Stop stops the server gracefully, it can be called multiple times and returns the result of the first call.
+// The listeners are closed first, then the v5 clients receive a DISCONNECT with 0x8B (Server shutting down).
+// The pending packets are written before the connections are closed,
+// and the unacknowledged messages are kept in the queue store for the persistent sessions.
+// The stores are closed after all the connections are done, or ctx.Err() is returned if ctx is done before that.
+func (s *server) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		s.stopErr = s.stop(ctx)
+	})
+	return s.stopErr
+}
+
+func (s *server) stop(ctx context.Context) error {
+	s.log.Info("stopping server")
+	// 停止接收新的连接
+	for _, l := range s.listeners {
+		if err := l.close(); err != nil {
+			s.log.Error("close listener", zap.String("name", l.config.Name), zap.Error(err))
+		}
+	}
+	s.mu.Lock()
+	// 此后新的客户端无法注册, 见 registerClient
+	close(s.exit)
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	s.hooks.onStop(ctx)
+
+	// 不读取数据的客户端会阻塞写入，并发关闭所有客户端
+	for _, c := range clients {
+		c := c
+		goroutine.Go(func() {
+			c.shutdown(ctx)
+		})
+	}
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			// 超时后强制关闭剩余的连接
+			for _, c := range clients {
+				_ = c.Close()
+			}
+			s.stopWills()
+			s.log.Warn("stop server timeout", zap.Error(ctx.Err()))
+			return ctx.Err()
+		}
+	}
+	// 等待中的遗嘱消息不能在存储关闭后发布
+	s.stopWills()
+
+	var err error
+	if e := s.subscriptionStore.Close(); e != nil {
+		s.log.Error("close subscription store", zap.Error(e))
+		err = e
+	}
+	if e := s.sessionStore.Close(); e != nil {
+		s.log.Error("close session store", zap.Error(e))
+		if err == nil {
+			err = e
+		}
+	}
+	if e := s.retainedStore.Close(); e != nil {
+		s.log.Error("close retained store", zap.Error(e))
+		if err == nil {
+			err = e
+		}
+	}
+	s.log.Info("server stopped")
+	return err
+}
+
+// terminateSession removes the session, the subscriptions and the queue of the client.
+func (s *server) terminateSession(ctx context.Context, clientId string, reason SessionTerminatedReason) {
+	logger := s.log.WithContext(ctx)
+	if err := s.sessionStore.Remove(ctx, clientId); err != nil {
+		logger.Error("remove session", zap.String("clientId", clientId), zap.Error(err))
+	}
+	if err := s.subscriptionStore.UnsubscribeAll(ctx, clientId); err != nil {
+		logger.Error("remove subscriptions", zap.String("clientId", clientId), zap.Error(err))
+	}
+	s.mu.Lock()
+	q, ok := s.queueStore[clientId]
+	u, uok := s.unackStore[clientId]
+	delete(s.queueStore, clientId)
+	delete(s.queueNotifiers, clientId)
+	delete(s.unackStore, clientId)
+	s.mu.Unlock()
+	// 未加载的存储（如服务重启后）也需要清理后端数据
+	var err error
+	if !ok {
+		q, err = s.newQueueStore(s.queueConfig, &queue.Options{ClientId: clientId, DefaultNotifier: newQueueNotifier(clientId, s.hooks)})
+	}
+	if err == nil {
+		err = q.Clean(ctx)
+	}
+	if err != nil {
+		logger.Error("clean queue", zap.String("clientId", clientId), zap.Error(err))
+	}
+	err = nil
+	if !uok {
+		u, err = s.newUnackStore(s.unackConfig, &unack.Options{ClientId: clientId})
+	}
+	if err == nil {
+		err = u.Init(ctx, true)
+	}
+	if err != nil {
+		logger.Error("clean unack store", zap.String("clientId", clientId), zap.Error(err))
+	}
+	s.hooks.onSessionTerminated(ctx, clientId, reason)
+}
+
+// registerClient registers the connected client, it returns the previous client with the same client id.
+// It returns false if the server is stopping.
+func (s *server) registerClient(c *client) (old *client, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.exit:
+		return nil, false
+	default:
+	}
+	old = s.clients[c.clientId]
+	s.clients[c.clientId] = c
+	return old, true
+}
+
+// unregisterClient removes the client if it has not been taken over by a new connection.
+func (s *server) unregisterClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c.clientId] == c {
+		delete(s.clients, c.clientId)
+	}
+}
+
+// getUnackStore returns the unack store of the given client, the store will be created if it does not exist.
+func (s *server) getUnackStore(clientId string) (unack.Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.unackStore[clientId]; ok {
+		return u, nil
+	}
+	u, err := s.newUnackStore(s.unackConfig, &unack.Options{ClientId: clientId})
+	if err != nil {
+		return nil, err
+	}
+	s.unackStore[clientId] = u
+	return u, nil
+}
+
+// deliverMessage routes the message to the queues of all matched subscribers.
+// srcClientId is the client id of the publisher.
+// It returns whether there is any subscriber matched.
+func (s *server) deliverMessage(ctx context.Context, srcClientId string, msg *message.Message) (matched bool) {
+	subs := subscription.GetTopicMatched(ctx, s.subscriptionStore, msg.Topic, subscription.TypeAll)
+	groups := make(map[string]sharedGroup)
+	for clientId, clientSubs := range subs {
+		// 共享订阅按共享组投递，每个共享组只投递给一个成员
+		nonShared := clientSubs[:0:0]
+		for _, cs := range clientSubs {
+			if cs.ShareName == "" {
+				nonShared = append(nonShared, cs)
+				continue
+			}
+			group := subscription.GetFullTopicName(cs.ShareName, cs.TopicFilter)
+			groups[group] = append(groups[group], sharedMember{clientId: clientId, sub: cs})
+		}
+		if s.deliverTo(ctx, srcClientId, clientId, msg, nonShared) {
+			matched = true
+		}
+	}
+	for group, members := range groups {
+		member, ok := s.selectSharedMember(ctx, group, srcClientId, msg, members)
+		if ok && s.deliverTo(ctx, srcClientId, member.clientId, msg, []*sub.Subscription{member.sub}) {
+			matched = true
+		}
+	}
+	return
+}
+
+// deliverTo adds the messages of the subscriptions into the queue of the client.
+// It returns whether there is any message added.
+func (s *server) deliverTo(ctx context.Context, srcClientId, clientId string, msg *message.Message, subs []*sub.Subscription) bool {
+	if len(subs) == 0 {
+		return false
+	}
+	q, ok := s.loadQueueStore(ctx, clientId)
+	if !ok {
+		return false
+	}
+	msgs := s.newDeliverMessages(srcClientId, clientId, msg, subs)
+	if len(msgs) == 0 {
+		return false
+	}
+	_ = s.enqueue(ctx, clientId, q, msgs...)
+	return true
 }
@@ -17,7 +17,22 @@
 package server
 
 import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/acl"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	_ "github.com/yunqi/lighthouse/internal/persistence/queue/mem"
+	_ "github.com/yunqi/lighthouse/internal/persistence/retained/trie"
+	_ "github.com/yunqi/lighthouse/internal/persistence/session/memory"
+	_ "github.com/yunqi/lighthouse/internal/persistence/subscription/memory"
+	_ "github.com/yunqi/lighthouse/internal/persistence/unack/mem"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestName(t *testing.T) {
@@ -26,3 +41,292 @@ func TestName(t *testing.T) {
 	//newServer.serveTCP()
 	//select {}
 }
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *packet.Reader
+	w    *packet.Writer
+	isV5 bool
+}
+
+func newTestServer(t *testing.T, opts ...Option) *server {
+	memory := config.StoreType{Type: persistence.Memory}
+	opts = append([]Option{
+		WithListener(&config.Listener{Name: "tcp", Protocol: config.ProtocolTCP, Address: "127.0.0.1:0"}),
+		WithPersistence(&config.Persistence{Session: memory, Subscription: memory, Queue: memory, Retained: memory, Unack: memory}),
+	}, opts...)
+	s := NewServer(opts...)
+	go s.Serve()
+	t.Cleanup(func() {
+		_ = s.Stop(context.Background())
+	})
+	return s
+}
+
+// listenerAddr returns the address of the named listener.
+func listenerAddr(t *testing.T, s *server, name string) net.Addr {
+	for _, l := range s.listeners {
+		if l.config.Name == name {
+			return l.ln.Addr()
+		}
+	}
+	t.Fatalf("listener %q not found", name)
+	return nil
+}
+
+func dial(t *testing.T, s *server) *testClient {
+	return dialListener(t, s, "tcp")
+}
+
+// dialListener connects to the named tcp or unix listener.
+func dialListener(t *testing.T, s *server, name string) *testClient {
+	addr := listenerAddr(t, s, name)
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &testClient{
+		t:    t,
+		conn: conn,
+		r:    packet.NewReader(conn),
+		w:    packet.NewWriter(conn),
+	}
+}
+
+func (c *testClient) version() packet.Version {
+	if c.isV5 {
+		return packet.Version5
+	}
+	return packet.Version311
+}
+
+func (c *testClient) write(p packet.Packet) {
+	if err := c.w.WritePacketAndFlush(p); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() packet.Packet {
+	_ = c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	p, err := c.r.Read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p
+}
+
+func (c *testClient) connect(clientId string, cleanSession bool) *packet.Connack {
+	c.write(&packet.Connect{
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(packet.Version311),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: cleanSession},
+		KeepAlive:     60,
+		ClientId:      []byte(clientId),
+	})
+	connack, ok := c.read().(*packet.Connack)
+	if !ok {
+		c.t.Fatal("expect connack")
+	}
+	return connack
+}
+
+func (c *testClient) connectV5(clientId string, cleanStart bool, props *packet.Properties) *packet.Connack {
+	c.isV5 = true
+	c.r.SetVersion(packet.Version5)
+	c.write(&packet.Connect{
+		Version:       packet.Version5,
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(packet.Version5),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: cleanStart},
+		KeepAlive:     60,
+		ClientId:      []byte(clientId),
+		Properties:    props,
+	})
+	connack, ok := c.read().(*packet.Connack)
+	if !ok {
+		c.t.Fatal("expect connack")
+	}
+	return connack
+}
+
+func (c *testClient) subscribe(packetId packet.Id, topics ...*packet.Topic) *packet.Suback {
+	c.write(&packet.Subscribe{Version: c.version(), PacketId: packetId, Topics: topics})
+	suback, ok := c.read().(*packet.Suback)
+	if !ok {
+		c.t.Fatal("expect suback")
+	}
+	return suback
+}
+
+func TestServer_routePublish(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	suback := sub.subscribe(1, &packet.Topic{Name: "a/+", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+	a.Equal([]code.Code{code.GrantedQoS1}, suback.Payload)
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	pub.write(&packet.Publish{QoS: packet.QoS0, TopicName: []byte("b/c"), Payload: []byte("ignored")})
+	pub.write(&packet.Publish{QoS: packet.QoS2, PacketId: 10, TopicName: []byte("a/b"), Payload: []byte("hello")})
+	_, ok := pub.read().(*packet.Pubrec)
+	a.True(ok)
+
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("a/b", string(publish.TopicName))
+	a.Equal([]byte("hello"), publish.Payload)
+	// downgrade to the subscription QoS
+	a.Equal(packet.QoS1, publish.QoS)
+	a.NotZero(publish.PacketId)
+}
+
+func TestServer_routePublish_V5(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connectV5("sub", true, nil).Code)
+	sub.write(&packet.Subscribe{
+		Version:    packet.Version5,
+		PacketId:   1,
+		Topics:     []*packet.Topic{{Name: "a/b", SubOptions: packet.SubOptions{QoS: packet.QoS1}}},
+		Properties: &packet.Properties{SubscriptionIdentifier: []uint32{5}},
+	})
+	suback, ok := sub.read().(*packet.Suback)
+	a.True(ok)
+	a.Equal([]code.Code{code.GrantedQoS1}, suback.Payload)
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connectV5("pub", true, nil).Code)
+	expiry := uint32(100)
+	pub.write(&packet.Publish{
+		Version:   packet.Version5,
+		QoS:       packet.QoS1,
+		PacketId:  1,
+		TopicName: []byte("a/b"),
+		Payload:   []byte("hello"),
+		Properties: &packet.Properties{
+			MessageExpiry: &expiry,
+			ContentType:   []byte("text/plain"),
+			User:          []packet.UserProperty{{K: []byte("k"), V: []byte("v")}},
+		},
+	})
+	puback, ok := pub.read().(*packet.Puback)
+	a.True(ok)
+	a.Equal(code.Success, puback.Code)
+
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("hello"), publish.Payload)
+	a.Equal([]uint32{5}, publish.Properties.SubscriptionIdentifier)
+	a.Equal([]byte("text/plain"), publish.Properties.ContentType)
+	a.Equal([]packet.UserProperty{{K: []byte("k"), V: []byte("v")}}, publish.Properties.User)
+	a.NotNil(publish.Properties.MessageExpiry)
+}
+
+func TestServer_topicAliasInvalid(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("alias", true, nil).Code)
+	alias := uint16(1)
+	c.write(&packet.Publish{
+		Version:    packet.Version5,
+		TopicName:  []byte("a/b"),
+		Properties: &packet.Properties{TopicAlias: &alias},
+	})
+	disconnect, ok := c.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.TopicAliasInvalid, disconnect.Code)
+}
+
+func TestServer_acl(t *testing.T) {
+	a := assert.New(t)
+	rules, err := acl.New(&config.ACL{
+		NoMatch: acl.Deny,
+		Rules:   []config.ACLRule{{Permission: acl.Allow, Topics: []string{"devices/%c/#"}}},
+	})
+	a.NoError(err)
+	s := newTestServer(t, WithACL(rules))
+
+	v3 := dial(t, s)
+	a.Equal(code.Success, v3.connect("A", true).Code)
+	suback := v3.subscribe(1,
+		&packet.Topic{Name: "devices/A/#", SubOptions: packet.SubOptions{QoS: packet.QoS1}},
+		&packet.Topic{Name: "devices/B/#", SubOptions: packet.SubOptions{QoS: packet.QoS1}},
+	)
+	a.Equal([]code.Code{code.GrantedQoS1, packet.SubscribeFailure}, suback.Payload)
+
+	v5 := dial(t, s)
+	a.Equal(code.Success, v5.connectV5("B", true, nil).Code)
+	suback = v5.subscribe(1, &packet.Topic{Name: "devices/A/#", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+	a.Equal([]code.Code{code.NotAuthorized}, suback.Payload)
+
+	// v5 denied publish gets the reason code
+	v5.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS1, PacketId: 1, TopicName: []byte("devices/A/x")})
+	puback, ok := v5.read().(*packet.Puback)
+	a.True(ok)
+	a.Equal(code.NotAuthorized, puback.Code)
+
+	// v3 denied publish is dropped silently
+	v3.write(&packet.Publish{QoS: packet.QoS1, PacketId: 2, TopicName: []byte("devices/B/x")})
+	puback, ok = v3.read().(*packet.Puback)
+	a.True(ok)
+	a.Equal(code.Success, puback.Code)
+
+	v5.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS0, TopicName: []byte("devices/B/x"), Payload: []byte("allowed")})
+	v3.write(&packet.Publish{QoS: packet.QoS0, TopicName: []byte("devices/A/x"), Payload: []byte("self")})
+	publish, ok := v3.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("self"), publish.Payload)
+}
+
+// rewriteHook rewrites the subscribed topic "mine" to "devices/B/#",
+// and the published topic "mine" to "devices/B/x".
+type rewriteHook struct {
+	HookBase
+}
+
+func (rewriteHook) OnMsgArrived(_ context.Context, _ Client, msg *message.Message) error {
+	if msg.Topic == "mine" {
+		msg.Topic = "devices/B/x"
+	}
+	return nil
+}
+
+func (rewriteHook) OnSubscribe(_ context.Context, _ Client, topic *packet.Topic) error {
+	if topic.Name == "mine" {
+		topic.Name = "devices/B/#"
+	}
+	return nil
+}
+
+func TestServer_aclAfterHook(t *testing.T) {
+	a := assert.New(t)
+	rules, err := acl.New(&config.ACL{
+		NoMatch: acl.Deny,
+		Rules:   []config.ACLRule{{Permission: acl.Allow, Topics: []string{"devices/%c/#", "mine"}}},
+	})
+	a.NoError(err)
+	s := newTestServer(t, WithACL(rules), WithHook(rewriteHook{}))
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connectV5("A", true, nil).Code)
+	suback := c.subscribe(1, &packet.Topic{Name: "mine", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+	a.Equal([]code.Code{code.NotAuthorized}, suback.Payload)
+
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS1, PacketId: 2, TopicName: []byte("mine")})
+	puback, ok := c.read().(*packet.Puback)
+	a.True(ok)
+	a.Equal(code.NotAuthorized, puback.Code)
+}
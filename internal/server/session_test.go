@@ -0,0 +1,191 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/session"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestServer_sessionResume(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	connack := c.connect("device", false)
+	a.Equal(code.Success, connack.Code)
+	a.False(connack.SessionPresent)
+	c.subscribe(1, &packet.Topic{Name: "cmd/#", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: 1, TopicName: []byte("cmd/a"), Payload: []byte("inflight")})
+	_, ok := pub.read().(*packet.Puback)
+	a.True(ok)
+	// 收到消息但不确认
+	publish, ok := c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("inflight"), publish.Payload)
+	_ = c.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// 离线期间的消息
+	pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: 2, TopicName: []byte("cmd/b"), Payload: []byte("offline")})
+	_, ok = pub.read().(*packet.Puback)
+	a.True(ok)
+
+	c = dial(t, s)
+	connack = c.connect("device", false)
+	a.Equal(code.Success, connack.Code)
+	a.True(connack.SessionPresent)
+	publish, ok = c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("inflight"), publish.Payload)
+	a.True(publish.Dup)
+	publish, ok = c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("offline"), publish.Payload)
+	_ = c.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// Clean Session 清除旧会话
+	c = dial(t, s)
+	connack = c.connect("device", true)
+	a.Equal(code.Success, connack.Code)
+	a.False(connack.SessionPresent)
+	pub.write(&packet.Publish{QoS: packet.QoS0, TopicName: []byte("cmd/c"), Payload: []byte("dropped")})
+	c.write(&packet.Pingreq{})
+	_, ok = c.read().(*packet.Pingresp)
+	a.True(ok)
+}
+
+func TestServer_sessionNotLoaded(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	// 服务重启后会话和订阅仍在存储中，但队列未加载
+	ctx := context.Background()
+	now := time.Now()
+	a.NoError(s.sessionStore.Set(ctx, &session.Session{ClientId: "device", ConnectedAt: now, DisconnectedAt: now, ExpiryInterval: 60}))
+	_, err := s.subscriptionStore.Subscribe(ctx, "device", &sub.Subscription{TopicFilter: "cmd/#", QoS: packet.QoS1})
+	a.NoError(err)
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: 1, TopicName: []byte("cmd/a"), Payload: []byte("offline")})
+	_, ok := pub.read().(*packet.Puback)
+	a.True(ok)
+
+	c := dial(t, s)
+	connack := c.connect("device", false)
+	a.Equal(code.Success, connack.Code)
+	a.True(connack.SessionPresent)
+	publish, ok := c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("offline"), publish.Payload)
+}
+
+func TestServer_sessionExpiryClamped(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	expiry := uint32(1<<32 - 1)
+	c := dial(t, s)
+	connack := c.connectV5("v5", true, &packet.Properties{SessionExpiryInterval: &expiry})
+	a.Equal(code.Success, connack.Code)
+	a.Equal(uint32(s.config.SessionExpiry/time.Second), *connack.Properties.SessionExpiryInterval)
+}
+
+func TestServer_takeover(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	expiry := uint32(60)
+	old := dial(t, s)
+	a.Equal(code.Success, old.connectV5("device", false, &packet.Properties{SessionExpiryInterval: &expiry}).Code)
+	old.subscribe(1, &packet.Topic{Name: "cmd", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+
+	c := dial(t, s)
+	connack := c.connectV5("device", false, &packet.Properties{SessionExpiryInterval: &expiry})
+	a.Equal(code.Success, connack.Code)
+	a.True(connack.SessionPresent)
+
+	disconnect, ok := old.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.SessionTakenOver, disconnect.Code)
+
+	// 订阅随会话转移
+	c.write(&packet.Publish{Version: packet.Version5, QoS: packet.QoS0, TopicName: []byte("cmd"), Payload: []byte("hello")})
+	publish, ok := c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("hello"), publish.Payload)
+
+	// v3 旧连接直接关闭
+	v3 := dial(t, s)
+	a.Equal(code.Success, v3.connect("v3", false).Code)
+	a.Equal(code.Success, dial(t, s).connect("v3", false).Code)
+	_ = v3.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err := v3.r.Read()
+	a.Equal(io.EOF, err)
+}
+
+func TestServer_takeoverNotReading(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	// 旧连接从不读取数据，服务端的写入会被阻塞
+	old := dial(t, s)
+	a.Equal(code.Success, old.connectV5("device", true, nil).Code)
+	old.subscribe(1, &packet.Topic{Name: "a"})
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connectV5("pub", true, nil).Code)
+	payload := make([]byte, 64*1024)
+	for i := 0; i < 500; i++ {
+		pub.write(&packet.Publish{Version: packet.Version5, TopicName: []byte("a"), Payload: payload})
+	}
+	pub.write(&packet.Pingreq{})
+	_, ok := pub.read().(*packet.Pingresp)
+	a.True(ok)
+
+	// 接管须在 kickTimeout 内完成，不能被旧连接阻塞
+	c := dial(t, s)
+	c.r.SetVersion(packet.Version5)
+	c.write(&packet.Connect{
+		Version:       packet.Version5,
+		FixedHeader:   &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:  []byte("MQTT"),
+		ProtocolLevel: byte(packet.Version5),
+		ConnectFlags:  packet.ConnectFlags{CleanSession: true},
+		KeepAlive:     60,
+		ClientId:      []byte("device"),
+	})
+	_ = c.conn.SetReadDeadline(time.Now().Add(kickTimeout + 3*time.Second))
+	p, err := c.r.Read()
+	a.NoError(err)
+	connack, ok := p.(*packet.Connack)
+	a.True(ok)
+	if ok {
+		a.Equal(code.Success, connack.Code)
+	}
+}
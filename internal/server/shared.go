@@ -0,0 +1,73 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	sub "github.com/yunqi/lighthouse/internal/subscription"
+	"sort"
+)
+
+type (
+	// sharedMember is a member of the share group which matches the message.
+	sharedMember struct {
+		clientId string
+		sub      *sub.Subscription
+	}
+	// sharedGroup is the members of a share group, a client can only subscribe a shared topic filter once.
+	sharedGroup []sharedMember
+)
+
+func (g sharedGroup) Len() int           { return len(g) }
+func (g sharedGroup) Less(i, j int) bool { return g[i].clientId < g[j].clientId }
+func (g sharedGroup) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+
+// selectSharedMember selects the member of the share group which receives the message.
+// The connected members whose queue is not full are preferred,
+// the offline or slow members are selected only if there is no other member available.
+func (s *server) selectSharedMember(ctx context.Context, group, srcClientId string, msg *message.Message, members sharedGroup) (sharedMember, bool) {
+	sort.Sort(members)
+	available := make(sharedGroup, 0, len(members))
+	s.mu.RLock()
+	for _, m := range members {
+		if _, ok := s.clients[m.clientId]; ok && !s.isSlowLocked(m.clientId) {
+			available = append(available, m)
+		}
+	}
+	s.mu.RUnlock()
+	if len(available) == 0 {
+		// 没有可用的在线成员时投递给保留会话的离线成员
+		for _, m := range members {
+			if _, ok := s.loadQueueStore(ctx, m.clientId); ok {
+				available = append(available, m)
+			}
+		}
+	}
+	if len(available) == 0 {
+		return sharedMember{}, false
+	}
+	clientIds := make([]string, len(available))
+	for i, m := range available {
+		clientIds[i] = m.clientId
+	}
+	i := s.sharedStrategy.Select(group, srcClientId, msg, clientIds)
+	if i < 0 || i >= len(available) {
+		i = 0
+	}
+	return available[i], true
+}
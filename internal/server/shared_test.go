@@ -0,0 +1,150 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/shared"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer_sharedSubscription(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.SharedSubStrategy = shared.RoundRobin
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	var workers []*testClient
+	for _, id := range []string{"w1", "w2"} {
+		w := dial(t, s)
+		a.Equal(code.Success, w.connect(id, true).Code)
+		suback := w.subscribe(1, &packet.Topic{Name: "$share/workers/job/+", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+		a.Equal([]code.Code{code.GrantedQoS1}, suback.Payload)
+		workers = append(workers, w)
+	}
+	monitor := dial(t, s)
+	a.Equal(code.Success, monitor.connect("monitor", true).Code)
+	monitor.subscribe(1, &packet.Topic{Name: "job/#"})
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	for i := 0; i < 4; i++ {
+		pub.write(&packet.Publish{QoS: packet.QoS0, TopicName: []byte("job/" + strconv.Itoa(i)), Payload: []byte("job")})
+	}
+
+	// 非共享订阅收到全部消息
+	for i := 0; i < 4; i++ {
+		_, ok := monitor.read().(*packet.Publish)
+		a.True(ok)
+	}
+	// 每条消息只投递给共享组的一个成员
+	for _, w := range workers {
+		for i := 0; i < 2; i++ {
+			publish, ok := w.read().(*packet.Publish)
+			a.True(ok)
+			a.Equal([]byte("job"), publish.Payload)
+			w.write(&packet.Puback{PacketId: publish.PacketId})
+		}
+		w.write(&packet.Pingreq{})
+		_, ok := w.read().(*packet.Pingresp)
+		a.True(ok)
+	}
+
+	// 不同共享组各收到一份
+	other := dial(t, s)
+	a.Equal(code.Success, other.connect("other", true).Code)
+	other.subscribe(1, &packet.Topic{Name: "$share/other/job/+"})
+	pub.write(&packet.Publish{QoS: packet.QoS0, TopicName: []byte("job/4"), Payload: []byte("job")})
+	_, ok := other.read().(*packet.Publish)
+	a.True(ok)
+}
+
+func TestServer_sharedSubscriptionFallback(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.SharedSubStrategy = shared.Sticky
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	online := dial(t, s)
+	a.Equal(code.Success, online.connect("online", true).Code)
+	online.subscribe(1, &packet.Topic{Name: "$share/g/job", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+	offline := dial(t, s)
+	a.Equal(code.Success, offline.connect("offline", false).Code)
+	offline.subscribe(1, &packet.Topic{Name: "$share/g/job", SubOptions: packet.SubOptions{QoS: packet.QoS1}})
+	_ = offline.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connect("pub", true).Code)
+	for i := 0; i < 3; i++ {
+		pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: packet.Id(i + 1), TopicName: []byte("job"), Payload: []byte("job")})
+		_, ok := pub.read().(*packet.Puback)
+		a.True(ok)
+	}
+	// 离线成员不接收消息
+	for i := 0; i < 3; i++ {
+		publish, ok := online.read().(*packet.Publish)
+		a.True(ok)
+		online.write(&packet.Puback{PacketId: publish.PacketId})
+	}
+
+	// 没有在线成员时投递给保留会话的离线成员
+	_ = online.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	pub.write(&packet.Publish{QoS: packet.QoS1, PacketId: 4, TopicName: []byte("job"), Payload: []byte("queued")})
+	_, ok := pub.read().(*packet.Puback)
+	a.True(ok)
+	offline = dial(t, s)
+	a.True(offline.connect("offline", false).SessionPresent)
+	publish, ok := offline.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("queued"), publish.Payload)
+}
+
+func TestServer_sharedSubNotAvailable(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.SharedSubAvailable = false
+	s := newTestServer(t, WithMqtt(&mqtt))
+
+	c := dial(t, s)
+	connack := c.connectV5("v5", true, nil)
+	a.Equal(code.Success, connack.Code)
+	a.Equal(byte(0), *connack.Properties.SharedSubAvailable)
+	suback := c.subscribe(1, &packet.Topic{Name: "$share/g/job"}, &packet.Topic{Name: "job"})
+	a.Equal([]code.Code{code.SharedSubNotSupported, code.GrantedQoS0}, suback.Payload)
+
+	c = dial(t, s)
+	a.Equal(code.Success, c.connect("v3", true).Code)
+	suback = c.subscribe(1, &packet.Topic{Name: "$share/g/job"})
+	a.Equal([]code.Code{packet.SubscribeFailure}, suback.Payload)
+}
+
+func TestServer_sharedSubInvalid(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	c := dial(t, s)
+	a.Equal(code.Success, c.connect("v3", true).Code)
+	suback := c.subscribe(1, &packet.Topic{Name: "$share//job"}, &packet.Topic{Name: "$share/g"}, &packet.Topic{Name: "$share/+/job"})
+	a.Equal([]code.Code{packet.SubscribeFailure, packet.SubscribeFailure, packet.SubscribeFailure}, suback.Payload)
+}
@@ -0,0 +1,146 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockHook blocks OnClosed until release is closed.
+type blockHook struct {
+	HookBase
+	release chan struct{}
+}
+
+func (h *blockHook) OnClosed(context.Context, Client, error) {
+	<-h.release
+}
+
+func TestServer_Stop(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	v5 := dial(t, s)
+	expiry := uint32(60)
+	a.Equal(code.Success, v5.connectV5("v5", true, &packet.Properties{SessionExpiryInterval: &expiry}).Code)
+	v3 := dial(t, s)
+	a.Equal(code.V3Accepted, v3.connect("v3", true).Code)
+	addr := listenerAddr(t, s, "tcp")
+
+	a.NoError(s.Stop(context.Background()))
+	// 可以重复调用
+	a.NoError(s.Stop(context.Background()))
+
+	disconnect, ok := v5.read().(*packet.Disconnect)
+	a.True(ok)
+	a.Equal(code.ServerShuttingDown, disconnect.Code)
+	_, err := v5.r.Read()
+	a.Error(err)
+	_ = v3.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = v3.r.Read()
+	a.Error(err)
+
+	// 持久会话被保存, 非持久会话被删除
+	sess, err := s.sessionStore.Get(context.Background(), "v5")
+	a.NoError(err)
+	a.NotNil(sess)
+	a.False(sess.DisconnectedAt.IsZero())
+	sess, err = s.sessionStore.Get(context.Background(), "v3")
+	a.NoError(err)
+	a.Nil(sess)
+
+	// 不再接收新的连接
+	_, err = net.Dial(addr.Network(), addr.String())
+	a.Error(err)
+}
+
+func TestServer_StopTimeout(t *testing.T) {
+	a := assert.New(t)
+	hook := &blockHook{release: make(chan struct{})}
+	s := newTestServer(t, WithHook(hook))
+	t.Cleanup(func() {
+		close(hook.release)
+	})
+
+	c := dial(t, s)
+	a.Equal(code.V3Accepted, c.connect("A", true).Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a.ErrorIs(s.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestServer_StopNotReading(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	// 订阅者从不读取数据，服务端的写入会被阻塞
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connectV5("sub", true, nil).Code)
+	sub.subscribe(1, &packet.Topic{Name: "a"})
+	pub := dial(t, s)
+	a.Equal(code.Success, pub.connectV5("pub", true, nil).Code)
+	payload := make([]byte, 64*1024)
+	for i := 0; i < 500; i++ {
+		pub.write(&packet.Publish{Version: packet.Version5, TopicName: []byte("a"), Payload: payload})
+	}
+	pub.write(&packet.Pingreq{})
+	_, ok := pub.read().(*packet.Pingresp)
+	a.True(ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Stop(ctx)
+	}()
+	select {
+	case err := <-stopped:
+		a.ErrorIs(err, context.DeadlineExceeded)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop does not respect the context deadline")
+	}
+}
+
+func TestServer_StopWillDelay(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	expiry := uint32(60)
+	delay := uint32(60)
+	c := dial(t, s)
+	c.isV5 = true
+	a.Equal(code.Success, c.connectWithWill("delayed", true, &packet.Properties{SessionExpiryInterval: &expiry}, &packet.Properties{WillDelayInterval: &delay}).Code)
+	_ = c.conn.Close()
+	a.Eventually(func() bool {
+		s.willMu.Lock()
+		defer s.willMu.Unlock()
+		return len(s.willMessages) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 停止后不再有等待中的遗嘱消息
+	a.NoError(s.Stop(context.Background()))
+	s.willMu.Lock()
+	a.Nil(s.willMessages)
+	s.willMu.Unlock()
+}
@@ -0,0 +1,137 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/auth"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/xtls"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tlsAuthenticator struct {
+	mu       sync.Mutex
+	username string
+	state    *tls.ConnectionState
+}
+
+func (a *tlsAuthenticator) Authenticate(ctx context.Context, connect *packet.Connect, _ net.Addr) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.username = string(connect.Username)
+	a.state = auth.TLSFromContext(ctx)
+	return nil
+}
+
+// newTestCertificate creates a certificate signed by the parent, it is self-signed if the parent is nil.
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServer_mutualTLS(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	ca := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "device-1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	key, err := x509.MarshalECPrivateKey(serverCert.PrivateKey.(*ecdsa.PrivateKey))
+	a.NoError(err)
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "server.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]}), 0600))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "server.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+
+	authenticator := &tlsAuthenticator{}
+	s := newTestServer(t, WithAuthenticator(authenticator), WithListener(&config.Listener{
+		Name:     "tls",
+		Protocol: config.ProtocolTLS,
+		Address:  "127.0.0.1:0",
+		TLS: &config.TLS{
+			CertFile:     filepath.Join(dir, "server.crt"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.crt"),
+			Identity:     xtls.IdentityCN,
+		},
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	dialTLS := func(certificates ...tls.Certificate) *testClient {
+		conn, err := tls.Dial("tcp", listenerAddr(t, s, "tls").String(), &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", Certificates: certificates})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		return &testClient{t: t, conn: conn, r: packet.NewReader(conn), w: packet.NewWriter(conn)}
+	}
+
+	// 用户名取自客户端证书的 CN
+	c := dialTLS(clientCert)
+	a.Equal(code.V3Accepted, c.connect("tls", true).Code)
+	authenticator.mu.Lock()
+	a.Equal("device-1", authenticator.username)
+	a.NotNil(authenticator.state)
+	authenticator.mu.Unlock()
+
+	// 没有客户端证书的连接在握手时被拒绝
+	c = dialTLS()
+	_ = c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = c.r.Read()
+	a.Error(err)
+}
@@ -0,0 +1,159 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/yunqi/lighthouse/internal/proxyproto"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// wsSubprotocol is the websocket subprotocol of MQTT.
+const wsSubprotocol = "mqtt"
+
+var (
+	_ net.Conn = (*wsConn)(nil)
+
+	// ErrNonBinaryFrame is returned when a non-binary websocket frame is received [MQTT-6.0.0-1].
+	ErrNonBinaryFrame = errors.New("websocket frame is not binary")
+)
+
+// wsConn adapts the binary frames of the websocket connection into a net.Conn.
+type wsConn struct {
+	*websocket.Conn
+	r         io.Reader            // the reader of the current frame
+	tlsState  *tls.ConnectionState // nil if the websocket is not over TLS
+	authority string               // the TLS SNI in the PROXY header
+}
+
+// authorityKey is the context key of the TLS SNI in the PROXY header of the HTTP connection.
+type authorityKey struct{}
+
+func newWsConn(conn *websocket.Conn, tlsState *tls.ConnectionState) *wsConn {
+	return &wsConn{Conn: conn, tlsState: tlsState}
+}
+
+// connContext stores the TLS SNI in the PROXY header into the context of the HTTP connection.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if pc, ok := conn.(*proxyproto.Conn); ok {
+		if h, err := pc.Header(); err == nil && h.Authority() != "" {
+			return context.WithValue(ctx, authorityKey{}, h.Authority())
+		}
+	}
+	return ctx
+}
+
+// Read reads the data of the binary frames, a MQTT packet can span multiple frames [MQTT-6.0.0-2].
+func (c *wsConn) Read(p []byte) (n int, err error) {
+	for {
+		if c.r == nil {
+			var messageType int
+			messageType, c.r, err = c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				return 0, ErrNonBinaryFrame
+			}
+		}
+		n, err = c.r.Read(p)
+		if err == io.EOF {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write writes the data as a binary frame.
+func (c *wsConn) Write(p []byte) (n int, err error) {
+	if err = c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// newUpgrader returns the websocket upgrader which only accepts the allowed origins.
+func newUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	u := &websocket.Upgrader{
+		ReadBufferSize:  2048,
+		WriteBufferSize: 2048,
+		Subprotocols:    []string{wsSubprotocol},
+	}
+	if len(allowedOrigins) == 0 {
+		// 默认只允许没有 Origin 或者与 Host 同源的请求
+		return u
+	}
+	u.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range allowedOrigins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return u
+}
+
+// serveWebsocket upgrades the HTTP request to the websocket connection with the "mqtt" subprotocol.
+// The client must offer the "mqtt" subprotocol [MQTT-6.0.0-3].
+func (l *listener) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	if !hasSubprotocol(r, wsSubprotocol) {
+		l.log.Debug("websocket upgrade", zap.String("IP", r.RemoteAddr), zap.Strings("subprotocols", websocket.Subprotocols(r)))
+		http.Error(w, "the mqtt subprotocol is required", http.StatusBadRequest)
+		return
+	}
+	conn, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		l.log.Debug("websocket upgrade", zap.String("IP", r.RemoteAddr), zap.Error(err))
+		return
+	}
+	c := newWsConn(conn, r.TLS)
+	c.authority, _ = r.Context().Value(authorityKey{}).(string)
+	l.serveConn(c)
+}
+
+func hasSubprotocol(r *http.Request, subprotocol string) bool {
+	for _, p := range websocket.Subprotocols(r) {
+		if p == subprotocol {
+			return true
+		}
+	}
+	return false
+}
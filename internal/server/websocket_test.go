@@ -0,0 +1,122 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"net/http"
+	"testing"
+)
+
+func dialWebsocket(t *testing.T, s *server) (*testClient, *websocket.Conn) {
+	dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+	conn, _, err := dialer.Dial("ws://"+listenerAddr(t, s, "ws").String()+"/mqtt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	c := newWsConn(conn, nil)
+	return &testClient{
+		t:    t,
+		conn: c,
+		r:    packet.NewReader(c),
+		w:    packet.NewWriter(c),
+	}, conn
+}
+
+func TestServer_websocket(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t, WithListener(&config.Listener{Name: "ws", Protocol: config.ProtocolWS, Address: "127.0.0.1:0"}))
+
+	c, conn := dialWebsocket(t, s)
+	a.Equal("mqtt", conn.Subprotocol())
+	a.Equal(code.Success, c.connect("ws", true).Code)
+	c.subscribe(1, &packet.Topic{Name: "a"})
+
+	// 一个 MQTT 报文可以拆分为多个 websocket 帧
+	publish := &packet.Publish{QoS: packet.QoS0, TopicName: []byte("a"), Payload: []byte("hello")}
+	b := packetBytes(t, publish)
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, b[:3]))
+	a.NoError(conn.WriteMessage(websocket.BinaryMessage, b[3:]))
+	p, ok := c.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal([]byte("hello"), p.Payload)
+
+	// 非二进制帧关闭连接
+	a.NoError(conn.WriteMessage(websocket.TextMessage, []byte("text")))
+	_, err := c.r.Read()
+	a.Error(err)
+}
+
+func TestServer_websocketPath(t *testing.T) {
+	s := newTestServer(t, WithListener(&config.Listener{Name: "ws", Protocol: config.ProtocolWS, Address: "127.0.0.1:0", Path: "/ws"}))
+	_, _, err := websocket.DefaultDialer.Dial("ws://"+listenerAddr(t, s, "ws").String()+"/mqtt", nil)
+	assert.Error(t, err)
+}
+
+func packetBytes(t *testing.T, p packet.Packet) []byte {
+	b := &bytes.Buffer{}
+	if err := p.Encode(b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestServer_websocketSubprotocol(t *testing.T) {
+	s := newTestServer(t, WithListener(&config.Listener{Name: "ws", Protocol: config.ProtocolWS, Address: "127.0.0.1:0"}))
+	_, resp, err := websocket.DefaultDialer.Dial("ws://"+listenerAddr(t, s, "ws").String()+"/mqtt", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServer_websocketOrigin(t *testing.T) {
+	a := assert.New(t)
+	dialOrigin := func(s *server, origin string) error {
+		dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := dialer.Dial("ws://"+listenerAddr(t, s, "ws").String()+"/mqtt", header)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+
+	// 默认只允许同源请求
+	s := newTestServer(t, WithListener(&config.Listener{Name: "ws", Protocol: config.ProtocolWS, Address: "127.0.0.1:0"}))
+	a.NoError(dialOrigin(s, ""))
+	a.NoError(dialOrigin(s, "http://"+listenerAddr(t, s, "ws").String()))
+	a.Error(dialOrigin(s, "https://evil.example.com"))
+
+	s = newTestServer(t, WithListener(&config.Listener{Name: "ws", Protocol: config.ProtocolWS, Address: "127.0.0.1:0",
+		AllowedOrigins: []string{"https://example.com"}}))
+	a.NoError(dialOrigin(s, "https://EXAMPLE.com"))
+	a.Error(dialOrigin(s, "https://evil.example.com"))
+
+	s = newTestServer(t, WithListener(&config.Listener{Name: "ws", Protocol: config.ProtocolWS, Address: "127.0.0.1:0",
+		AllowedOrigins: []string{"*"}}))
+	a.NoError(dialOrigin(s, "https://evil.example.com"))
+}
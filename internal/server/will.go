@@ -0,0 +1,99 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"go.uber.org/zap"
+	"time"
+)
+
+// willMessage is the will message which is waiting for the Will Delay Interval.
+type willMessage struct {
+	msg   *message.Message
+	timer *time.Timer
+}
+
+// sendWill publishes the will message of the client after the delay in seconds.
+// The pending will message is replaced if there is one.
+func (s *server) sendWill(clientId string, msg *message.Message, delay uint32) {
+	if delay == 0 {
+		s.publishWill(context.Background(), clientId, msg)
+		return
+	}
+	s.willMu.Lock()
+	defer s.willMu.Unlock()
+	if s.willMessages == nil {
+		// 服务已停止
+		return
+	}
+	if w, ok := s.willMessages[clientId]; ok {
+		w.timer.Stop()
+	}
+	w := &willMessage{msg: msg}
+	w.timer = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+		s.willMu.Lock()
+		if s.willMessages[clientId] != w {
+			s.willMu.Unlock()
+			return
+		}
+		delete(s.willMessages, clientId)
+		s.willMu.Unlock()
+		s.publishWill(context.Background(), clientId, msg)
+	})
+	s.willMessages[clientId] = w
+}
+
+// stopWill stops the pending will message of the client.
+// If publish is true, the will message is published immediately, e.g. the session is ended.
+func (s *server) stopWill(clientId string, publish bool) {
+	s.willMu.Lock()
+	w, ok := s.willMessages[clientId]
+	if ok {
+		delete(s.willMessages, clientId)
+		w.timer.Stop()
+	}
+	s.willMu.Unlock()
+	if ok && publish {
+		s.publishWill(context.Background(), clientId, w.msg)
+	}
+}
+
+// publishWill publishes the will message as if it is published by the client.
+func (s *server) publishWill(ctx context.Context, clientId string, msg *message.Message) {
+	s.log.WithContext(ctx).Debug("publish will message", zap.String("clientId", clientId), zap.String("topic", msg.Topic))
+	if msg.Retained && s.config.RetainAvailable {
+		if len(msg.Payload) == 0 {
+			s.retainedStore.Remove(msg.Topic)
+		} else {
+			s.retainedStore.AddOrReplace(msg.Copy())
+		}
+	}
+	s.deliverMessage(ctx, clientId, msg)
+}
+
+// stopWills stops all pending will messages when the server is stopped,
+// the will messages sent after that are dropped.
+func (s *server) stopWills() {
+	s.willMu.Lock()
+	defer s.willMu.Unlock()
+	for _, w := range s.willMessages {
+		w.timer.Stop()
+	}
+	s.willMessages = nil
+}
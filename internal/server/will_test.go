@@ -0,0 +1,145 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+	"github.com/yunqi/lighthouse/internal/code"
+	"github.com/yunqi/lighthouse/internal/packet"
+	"github.com/yunqi/lighthouse/internal/persistence/subscription"
+	"testing"
+	"time"
+)
+
+func (c *testClient) connectWithWill(clientId string, cleanStart bool, props, willProps *packet.Properties) *packet.Connack {
+	connect := &packet.Connect{
+		Version:        c.version(),
+		FixedHeader:    &packet.FixedHeader{PacketType: packet.CONNECT},
+		ProtocolName:   []byte("MQTT"),
+		ProtocolLevel:  byte(c.version()),
+		ConnectFlags:   packet.ConnectFlags{CleanSession: cleanStart, WillFlag: true, WillQoS: packet.QoS0},
+		KeepAlive:      60,
+		ClientId:       []byte(clientId),
+		WillTopic:      []byte("will/" + clientId),
+		WillMessage:    []byte("offline"),
+		Properties:     props,
+		WillProperties: willProps,
+	}
+	if c.isV5 {
+		c.r.SetVersion(packet.Version5)
+	}
+	c.write(connect)
+	connack, ok := c.read().(*packet.Connack)
+	if !ok {
+		c.t.Fatal("expect connack")
+	}
+	return connack
+}
+
+func TestServer_will(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	sub.subscribe(1, &packet.Topic{Name: "will/#"})
+
+	// 正常断开连接，不发送遗嘱消息
+	normal := dial(t, s)
+	a.Equal(code.Success, normal.connectWithWill("normal", true, nil, nil).Code)
+	normal.write(&packet.Disconnect{})
+
+	// 异常断开连接，发送遗嘱消息
+	abnormal := dial(t, s)
+	a.Equal(code.Success, abnormal.connectWithWill("abnormal", true, nil, nil).Code)
+	_ = abnormal.conn.Close()
+
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("will/abnormal", string(publish.TopicName))
+	a.Equal([]byte("offline"), publish.Payload)
+}
+
+func TestServer_willDelay(t *testing.T) {
+	a := assert.New(t)
+	s := newTestServer(t)
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	sub.subscribe(1, &packet.Topic{Name: "will/#"})
+
+	expiry := uint32(60)
+	delay := uint32(1)
+	props := &packet.Properties{SessionExpiryInterval: &expiry}
+	willProps := &packet.Properties{WillDelayInterval: &delay}
+
+	// 在延迟时间内重连，取消遗嘱消息
+	resumed := dial(t, s)
+	resumed.isV5 = true
+	a.Equal(code.Success, resumed.connectWithWill("resumed", true, props, willProps).Code)
+	_ = resumed.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	resumed = dial(t, s)
+	a.Equal(code.Success, resumed.connectV5("resumed", false, props).Code)
+
+	delayed := dial(t, s)
+	delayed.isV5 = true
+	a.Equal(code.Success, delayed.connectWithWill("delayed", true, props, willProps).Code)
+	start := time.Now()
+	_ = delayed.conn.Close()
+
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("will/delayed", string(publish.TopicName))
+	a.True(time.Since(start) >= 900*time.Millisecond)
+}
+
+func TestServer_sessionExpired(t *testing.T) {
+	a := assert.New(t)
+	mqtt := config.DefaultMqtt
+	mqtt.SessionExpiryCheckInterval = 100 * time.Millisecond
+	h := newRecordHook()
+	s := newTestServer(t, WithMqtt(&mqtt), WithHook(h))
+
+	sub := dial(t, s)
+	a.Equal(code.Success, sub.connect("sub", true).Code)
+	sub.subscribe(1, &packet.Topic{Name: "will/#"})
+
+	expiry := uint32(1)
+	delay := uint32(100)
+	c := dial(t, s)
+	c.isV5 = true
+	a.Equal(code.Success, c.connectWithWill("expired", true, &packet.Properties{SessionExpiryInterval: &expiry}, &packet.Properties{WillDelayInterval: &delay}).Code)
+	c.subscribe(1, &packet.Topic{Name: "a"})
+	start := time.Now()
+	_ = c.conn.Close()
+
+	// 遗嘱延迟时间超过会话有效期，会话过期时发布
+	publish, ok := sub.read().(*packet.Publish)
+	a.True(ok)
+	a.Equal("will/expired", string(publish.TopicName))
+	a.True(time.Since(start) >= time.Second)
+
+	a.Eventually(func() bool {
+		session, err := s.sessionStore.Get(context.Background(), "expired")
+		return err == nil && session == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	a.Empty(subscription.GetClientSubscriptions(context.Background(), s.subscriptionStore, "expired", subscription.TypeAll))
+	a.Contains(h.calls(), "OnSessionTerminated")
+}
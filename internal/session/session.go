@@ -15,11 +15,19 @@ type Session struct {
 	WillDelayInterval uint32
 	// ConnectedAt is the session create time.
 	ConnectedAt time.Time
+	// DisconnectedAt is the time when the network connection is closed, zero if it is never closed.
+	DisconnectedAt time.Time
 	// ExpiryInterval represents the Session Expiry Interval in seconds
 	ExpiryInterval uint32
 }
 
-// IsExpired return whether the session is expired
+// IsExpired return whether the session is expired.
+// The Session Expiry Interval starts when the network connection is closed,
+// ConnectedAt is used if DisconnectedAt is not recorded, e.g. the server is not stopped gracefully.
 func (s *Session) IsExpired(now time.Time) bool {
-	return s.ConnectedAt.Add(time.Duration(s.ExpiryInterval) * time.Second).Before(now)
+	from := s.ConnectedAt
+	if s.DisconnectedAt.After(from) {
+		from = s.DisconnectedAt
+	}
+	return from.Add(time.Duration(s.ExpiryInterval) * time.Second).Before(now)
 }
@@ -0,0 +1,18 @@
+package session
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSession_IsExpired(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	s := &Session{ConnectedAt: now.Add(-time.Hour), ExpiryInterval: 60}
+	// 未记录断开时间时从连接时间开始计算
+	a.True(s.IsExpired(now))
+	s.DisconnectedAt = now.Add(-30 * time.Second)
+	a.False(s.IsExpired(now))
+	a.True(s.IsExpired(now.Add(31 * time.Second)))
+}
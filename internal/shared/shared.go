@@ -0,0 +1,125 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+// Package shared selects the subscriber of the shared subscriptions.
+// A message matched by a share group is delivered to only one member of the group [MQTT-4.8.2].
+package shared
+
+import (
+	"github.com/bytedance/gopkg/lang/fastrand"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const (
+	// Random selects a random member.
+	Random = "random"
+	// RoundRobin selects the members in turn.
+	RoundRobin = "roundRobin"
+	// Sticky selects the same member until it is not available.
+	Sticky = "sticky"
+	// HashClientId selects the member by the hash of the publisher client id,
+	// the messages of one publisher are always delivered to the same member.
+	HashClientId = "hashClientId"
+	// HashTopic selects the member by the hash of the topic name,
+	// the messages of one topic are always delivered to the same member.
+	HashTopic = "hashTopic"
+)
+
+var strategies = map[string]NewStrategy{
+	Random:       func() Strategy { return randomStrategy{} },
+	RoundRobin:   func() Strategy { return &roundRobinStrategy{next: make(map[string]uint64)} },
+	Sticky:       func() Strategy { return &stickyStrategy{members: make(map[string]string)} },
+	HashClientId: func() Strategy { return hashClientIdStrategy{} },
+	HashTopic:    func() Strategy { return hashTopicStrategy{} },
+}
+
+type (
+	// Strategy selects the member of the share group which receives the message.
+	// The implementation must be safe for concurrent use.
+	Strategy interface {
+		// Select returns the index of the selected member.
+		// group is the full shared topic filter "$share/{ShareName}/{filter}",
+		// srcClientId is the client id of the publisher, "" if the message is not published by a client.
+		// The members are the client ids sorted in ascending order, there is at least one member.
+		Select(group, srcClientId string, msg *message.Message, members []string) int
+	}
+	// NewStrategy creates a Strategy.
+	NewStrategy func() Strategy
+
+	randomStrategy       struct{}
+	hashClientIdStrategy struct{}
+	hashTopicStrategy    struct{}
+	roundRobinStrategy   struct {
+		mu   sync.Mutex
+		next map[string]uint64 // [group]
+	}
+	stickyStrategy struct {
+		mu      sync.Mutex
+		members map[string]string // [group]clientId
+	}
+)
+
+func RegisterStrategy(name string, fn NewStrategy) {
+	strategies[name] = fn
+}
+
+func GetStrategy(name string) (fn NewStrategy, ok bool) {
+	fn, ok = strategies[name]
+	return fn, ok
+}
+
+func (randomStrategy) Select(_, _ string, _ *message.Message, members []string) int {
+	return fastrand.Intn(len(members))
+}
+
+func (s *roundRobinStrategy) Select(group, _ string, _ *message.Message, members []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.next[group]
+	s.next[group] = n + 1
+	return int(n % uint64(len(members)))
+}
+
+func (s *stickyStrategy) Select(group, _ string, _ *message.Message, members []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if clientId, ok := s.members[group]; ok {
+		if i := sort.SearchStrings(members, clientId); i < len(members) && members[i] == clientId {
+			return i
+		}
+	}
+	// 原成员不可用时重新选择
+	i := fastrand.Intn(len(members))
+	s.members[group] = members[i]
+	return i
+}
+
+func (hashClientIdStrategy) Select(_, srcClientId string, _ *message.Message, members []string) int {
+	return hash(srcClientId, len(members))
+}
+
+func (hashTopicStrategy) Select(_, _ string, msg *message.Message, members []string) int {
+	return hash(msg.Topic, len(members))
+}
+
+func hash(s string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return int(h.Sum32() % uint32(n))
+}
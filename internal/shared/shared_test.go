@@ -0,0 +1,98 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/internal/persistence/message"
+	"testing"
+)
+
+func newStrategy(t *testing.T, name string) Strategy {
+	fn, ok := GetStrategy(name)
+	if !ok {
+		t.Fatalf("strategy %s not found", name)
+	}
+	return fn()
+}
+
+func TestRandom(t *testing.T) {
+	a := assert.New(t)
+	s := newStrategy(t, Random)
+	members := []string{"a", "b", "c"}
+	for i := 0; i < 100; i++ {
+		i := s.Select("$share/g/t", "", &message.Message{Topic: "t"}, members)
+		a.True(i >= 0 && i < len(members))
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	a := assert.New(t)
+	s := newStrategy(t, RoundRobin)
+	members := []string{"a", "b", "c"}
+	msg := &message.Message{Topic: "t"}
+	for i := 0; i < 6; i++ {
+		a.Equal(i%3, s.Select("$share/g1/t", "", msg, members))
+	}
+	// 不同共享组互不影响
+	a.Equal(0, s.Select("$share/g2/t", "", msg, members))
+}
+
+func TestSticky(t *testing.T) {
+	a := assert.New(t)
+	s := newStrategy(t, Sticky)
+	members := []string{"a", "b", "c"}
+	msg := &message.Message{Topic: "t"}
+	selected := members[s.Select("$share/g/t", "", msg, members)]
+	for i := 0; i < 10; i++ {
+		a.Equal(selected, members[s.Select("$share/g/t", "", msg, members)])
+	}
+	// 原成员不可用时选择其他成员，并保持新的成员
+	var rest []string
+	for _, m := range members {
+		if m != selected {
+			rest = append(rest, m)
+		}
+	}
+	selected = rest[s.Select("$share/g/t", "", msg, rest)]
+	a.Equal(selected, members[s.Select("$share/g/t", "", msg, members)])
+}
+
+func TestHash(t *testing.T) {
+	a := assert.New(t)
+	members := []string{"a", "b", "c", "d"}
+
+	s := newStrategy(t, HashClientId)
+	i := s.Select("$share/g/t", "publisher", &message.Message{Topic: "t1"}, members)
+	a.Equal(i, s.Select("$share/g/t", "publisher", &message.Message{Topic: "t2"}, members))
+
+	s = newStrategy(t, HashTopic)
+	i = s.Select("$share/g/t", "p1", &message.Message{Topic: "t1"}, members)
+	a.Equal(i, s.Select("$share/g/t", "p2", &message.Message{Topic: "t1"}, members))
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	RegisterStrategy("first", func() Strategy { return first{} })
+	defer delete(strategies, "first")
+	assert.Equal(t, 0, newStrategy(t, "first").Select("$share/g/t", "", &message.Message{}, []string{"a", "b"}))
+}
+
+type first struct{}
+
+func (first) Select(string, string, *message.Message, []string) int {
+	return 0
+}
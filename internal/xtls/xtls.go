@@ -0,0 +1,234 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/yunqi/lighthouse/config"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	IdentityCN  = "cn"
+	IdentitySAN = "san"
+
+	IdentityAsUsername = "username"
+	IdentityAsClientId = "clientId"
+
+	defaultReloadInterval = 10 * time.Second
+)
+
+var (
+	ErrNoClientCA = errors.New("xtls: no certificate found in the client CA file")
+	// ErrUnverifiedIdentity is returned if the identity is derived from the client certificates which are not verified.
+	ErrUnverifiedIdentity = errors.New("xtls: identity requires clientAuth verifyIfGiven or requireAndVerify")
+
+	versions = map[string]uint16{
+		"":    tls.VersionTLS12,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	clientAuths = map[string]tls.ClientAuthType{
+		"none":             tls.NoClientCert,
+		"request":          tls.RequestClientCert,
+		"require":          tls.RequireAnyClientCert,
+		"verifyIfGiven":    tls.VerifyClientCertIfGiven,
+		"requireAndVerify": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// reloader reloads the certificates when the files are changed.
+// The files are checked at most once every interval during the TLS handshakes.
+type reloader struct {
+	c         *config.TLS
+	base      *tls.Config
+	interval  time.Duration
+	mu        sync.Mutex
+	current   *tls.Config
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+// NewConfig returns the server tls.Config of the given configuration, the certificates are reloaded when the files are changed.
+func NewConfig(c *config.TLS) (*tls.Config, error) {
+	base := &tls.Config{}
+	var ok bool
+	if base.MinVersion, ok = versions[c.MinVersion]; !ok {
+		return nil, fmt.Errorf("xtls: invalid min version %q", c.MinVersion)
+	}
+	if len(c.CipherSuites) != 0 {
+		suites, err := cipherSuites(c.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		base.CipherSuites = suites
+	}
+	clientAuth := c.ClientAuth
+	if clientAuth == "" && c.ClientCAFile != "" {
+		clientAuth = "requireAndVerify"
+	} else if clientAuth == "" {
+		clientAuth = "none"
+	}
+	if base.ClientAuth, ok = clientAuths[clientAuth]; !ok {
+		return nil, fmt.Errorf("xtls: invalid client auth %q", c.ClientAuth)
+	}
+	// 未经验证的证书可以伪造任意身份
+	if c.Identity != "" && base.ClientAuth != tls.VerifyClientCertIfGiven && base.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil, ErrUnverifiedIdentity
+	}
+
+	r := &reloader{c: c, base: base, interval: c.ReloadInterval}
+	if r.interval <= 0 {
+		r.interval = defaultReloadInterval
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	// 每次握手使用重新加载后的完整配置，包括证书和客户端 CA
+	return &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.get(), nil
+		},
+	}, nil
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	all := make(map[string]uint16)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		all[s.Name] = s.ID
+	}
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("xtls: invalid cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+func (r *reloader) files() []string {
+	files := []string{r.c.CertFile, r.c.KeyFile}
+	if r.c.ClientCAFile != "" {
+		files = append(files, r.c.ClientCAFile)
+	}
+	return files
+}
+
+func (r *reloader) stat() ([]time.Time, error) {
+	files := r.files()
+	modTimes := make([]time.Time, 0, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// load loads the certificates from the files.
+func (r *reloader) load() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.c.CertFile, r.c.KeyFile)
+	if err != nil {
+		return err
+	}
+	c := r.base.Clone()
+	c.Certificates = []tls.Certificate{cert}
+	if r.c.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(r.c.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return ErrNoClientCA
+		}
+		c.ClientCAs = pool
+	}
+	r.current = c
+	r.modTimes = modTimes
+	r.checkedAt = time.Now()
+	return nil
+}
+
+// get returns the current tls.Config, the certificates are reloaded if the files are changed.
+// The previous certificates are kept if the new ones can not be loaded.
+func (r *reloader) get() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < r.interval {
+		return r.current
+	}
+	r.checkedAt = time.Now()
+	modTimes, err := r.stat()
+	if err != nil || equalTimes(modTimes, r.modTimes) {
+		return r.current
+	}
+	_ = r.load()
+	return r.current
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Identity returns the identity of the verified client certificate, it returns empty if the certificate is not verified.
+// IdentityCN returns the Common Name, IdentitySAN returns the first DNS name, email address or URI of the Subject Alternative Name.
+func Identity(state *tls.ConnectionState, from string) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	switch from {
+	case IdentityCN:
+		return cert.Subject.CommonName
+	case IdentitySAN:
+		if len(cert.DNSNames) != 0 {
+			return cert.DNSNames[0]
+		}
+		if len(cert.EmailAddresses) != 0 {
+			return cert.EmailAddresses[0]
+		}
+		if len(cert.URIs) != 0 {
+			return cert.URIs[0].String()
+		}
+	}
+	return ""
+}
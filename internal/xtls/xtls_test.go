@@ -0,0 +1,248 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yunqi/lighthouse/config"
+)
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCertificate(t *testing.T, template *x509.Certificate, parent *certificate) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &certificate{cert: cert, key: key}
+}
+
+func (c *certificate) write(t *testing.T, certFile, keyFile string) {
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	if keyFile == "" {
+		return
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600))
+}
+
+func (c *certificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func newCA(t *testing.T) *certificate {
+	return newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newServerCertificate(t *testing.T, ca *certificate, cn string) *certificate {
+	return newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+func newClientCertificate(t *testing.T, ca *certificate, cn string, dnsNames ...string) *certificate {
+	return newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		DNSNames:    dnsNames,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+// handshake connects to a server with the tls.Config and returns the certificate of the server and
+// the connection state seen by the server.
+func handshake(t *testing.T, serverConfig *tls.Config, clientConfig *tls.Config) (*x509.Certificate, *tls.ConnectionState, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	result := make(chan *tls.ConnectionState, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			result <- nil
+			return
+		}
+		defer c.Close()
+		conn := tls.Server(c, serverConfig)
+		if err := conn.Handshake(); err != nil {
+			result <- nil
+			return
+		}
+		state := conn.ConnectionState()
+		result <- &state
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+	if err != nil {
+		<-result
+		return nil, nil, err
+	}
+	defer conn.Close()
+	serverState := <-result
+	if serverState == nil {
+		return nil, nil, errors.New("server handshake failed")
+	}
+	return conn.ConnectionState().PeerCertificates[0], serverState, nil
+}
+
+func TestNewConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	ca.write(t, filepath.Join(dir, "ca.crt"), "")
+	newServerCertificate(t, ca, "server").write(t, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	c := &config.TLS{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	serverConfig, err := NewConfig(c)
+	assert.NoError(t, err)
+
+	t.Run("mutual", func(t *testing.T) {
+		client := newClientCertificate(t, ca, "client", "client.example.com")
+		cert, state, err := handshake(t, serverConfig, &tls.Config{
+			RootCAs:      pool,
+			ServerName:   "127.0.0.1",
+			Certificates: []tls.Certificate{client.tlsCertificate()},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "server", cert.Subject.CommonName)
+		assert.Equal(t, "client", Identity(state, IdentityCN))
+		assert.Equal(t, "client.example.com", Identity(state, IdentitySAN))
+	})
+	t.Run("noClientCertificate", func(t *testing.T) {
+		_, _, err := handshake(t, serverConfig, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+		assert.Error(t, err)
+	})
+	t.Run("minVersion", func(t *testing.T) {
+		client := newClientCertificate(t, ca, "client")
+		_, _, err := handshake(t, serverConfig, &tls.Config{
+			RootCAs:      pool,
+			ServerName:   "127.0.0.1",
+			Certificates: []tls.Certificate{client.tlsCertificate()},
+			MaxVersion:   tls.VersionTLS11,
+		})
+		assert.Error(t, err)
+	})
+
+	_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, MinVersion: "2.0"})
+	assert.Error(t, err)
+	_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, ClientAuth: "always"})
+	assert.Error(t, err)
+	_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, CipherSuites: []string{"TLS_NULL"}})
+	assert.Error(t, err)
+	_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}})
+	assert.NoError(t, err)
+	_, err = NewConfig(&config.TLS{CertFile: filepath.Join(dir, "none.crt"), KeyFile: c.KeyFile})
+	assert.Error(t, err)
+	_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, ClientCAFile: c.KeyFile})
+	assert.ErrorIs(t, err, ErrNoClientCA)
+	for _, clientAuth := range []string{"none", "request", "require"} {
+		_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, ClientCAFile: c.ClientCAFile, ClientAuth: clientAuth, Identity: IdentityCN})
+		assert.ErrorIs(t, err, ErrUnverifiedIdentity, clientAuth)
+	}
+	_, err = NewConfig(&config.TLS{CertFile: c.CertFile, KeyFile: c.KeyFile, ClientCAFile: c.ClientCAFile, ClientAuth: "verifyIfGiven", Identity: IdentityCN})
+	assert.NoError(t, err)
+}
+
+func TestNewConfig_reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca := newCA(t)
+	newServerCertificate(t, ca, "old").write(t, certFile, keyFile)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	clientConfig := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+
+	serverConfig, err := NewConfig(&config.TLS{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Millisecond})
+	assert.NoError(t, err)
+	cert, _, err := handshake(t, serverConfig, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", cert.Subject.CommonName)
+
+	// the previous certificate is kept if the new one is broken
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte("broken"), 0600))
+	modTime := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	time.Sleep(2 * time.Millisecond)
+	cert, _, err = handshake(t, serverConfig, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", cert.Subject.CommonName)
+
+	newServerCertificate(t, ca, "new").write(t, certFile, keyFile)
+	modTime = modTime.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	assert.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	time.Sleep(2 * time.Millisecond)
+	cert, _, err = handshake(t, serverConfig, clientConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", cert.Subject.CommonName)
+}
+
+func TestIdentity(t *testing.T) {
+	assert.Equal(t, "", Identity(nil, IdentityCN))
+	assert.Equal(t, "", Identity(&tls.ConnectionState{}, IdentityCN))
+
+	ca := newCA(t)
+	cert := newClientCertificate(t, ca, "client").cert
+	// 未经验证的证书没有身份
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	assert.Equal(t, "", Identity(state, IdentityCN))
+
+	state.VerifiedChains = [][]*x509.Certificate{{cert, ca.cert}}
+	assert.Equal(t, "client", Identity(state, IdentityCN))
+	assert.Equal(t, "", Identity(state, IdentitySAN))
+	assert.Equal(t, "", Identity(state, "unknown"))
+}